@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// TorrentList is a page of torrents returned by the API
+type TorrentList struct {
+	Total    int                    `json:"total"`
+	Offset   int                    `json:"offset"`
+	Limit    int                    `json:"limit"`
+	Torrents []transmission.Torrent `json:"torrents"`
+}
+
+// API serves the cached torrents and the daemon's session and statistics as read-only JSON, so that
+// they can be queried without credentials to the daemon
+type API struct {
+	logger *zap.Logger
+	cache  *TorrentCache
+	client *transmission.Client
+}
+
+// NewAPI creates an API. All torrent fields are requested from Transmission from then on.
+func NewAPI(logger *zap.Logger, cache *TorrentCache, client *transmission.Client) *API {
+	cache.AddFields(transmission.AllTorrentFields...)
+	return &API{
+		logger: logger,
+		cache:  cache,
+		client: client,
+	}
+}
+
+// torrentSorts are the values of the sort parameter
+var torrentSorts = map[string]func([]transmission.Torrent) sort.Interface{
+	"id":    func(t []transmission.Torrent) sort.Interface { return transmission.ByID(t) },
+	"name":  func(t []transmission.Torrent) sort.Interface { return transmission.ByName(t) },
+	"date":  func(t []transmission.Torrent) sort.Interface { return transmission.ByDate(t) },
+	"ratio": func(t []transmission.Torrent) sort.Interface { return transmission.ByRatio(t) },
+}
+
+// sortTorrents sorts torrents by id, name, date or ratio, or by id if by is empty. Torrents sorting
+// equally are ordered by id, so that they keep a stable order across pages.
+func sortTorrents(torrents []transmission.Torrent, by string, desc bool) error {
+	sortBy, ok := torrentSorts[by]
+	if by == "" {
+		sortBy, ok = torrentSorts["id"], true
+	}
+	if !ok {
+		return fmt.Errorf("unknown sort order %q", by)
+	}
+
+	sort.Sort(transmission.ByID(torrents))
+	if desc {
+		sort.Stable(sort.Reverse(sortBy(torrents)))
+	} else {
+		sort.Stable(sortBy(torrents))
+	}
+	return nil
+}
+
+// TorrentsHandler lists the torrents matching the filter parameters, which are named like the
+// filter flags without the include prefix, e.g. status=download&exclude_label=private. They are
+// sorted by the sort parameter (id, name, date or ratio, descending with order=desc) and paginated
+// with the offset and limit parameters.
+func (a *API) TorrentsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var minSize int64
+		if s := q.Get("min_size"); s != "" {
+			var err error
+			if minSize, err = strconv.ParseInt(s, 10, 64); err != nil {
+				http.Error(w, "invalid min_size parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		filter, err := NewTorrentFilter(TorrentFilterConfig{
+			IncludeName:     q.Get("name"),
+			ExcludeName:     q.Get("exclude_name"),
+			IncludeDirs:     q["dir"],
+			ExcludeDirs:     q["exclude_dir"],
+			IncludeLabels:   q["label"],
+			ExcludeLabels:   q["exclude_label"],
+			IncludeTrackers: q["tracker"],
+			ExcludeTrackers: q["exclude_tracker"],
+			IncludeStatuses: q["status"],
+			ExcludeStatuses: q["exclude_status"],
+			MinSize:         minSize,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sortBy := q.Get("sort")
+		if _, ok := torrentSorts[sortBy]; !ok && sortBy != "" {
+			http.Error(w, "invalid sort parameter", http.StatusBadRequest)
+			return
+		}
+		desc := false
+		switch q.Get("order") {
+		case "", "asc":
+		case "desc":
+			desc = true
+		default:
+			http.Error(w, "invalid order parameter", http.StatusBadRequest)
+			return
+		}
+
+		offset, limit := 0, 0
+		if s := q.Get("offset"); s != "" {
+			if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+				http.Error(w, "invalid offset parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if s := q.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		all, err := a.cache.Torrents()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		torrents := []transmission.Torrent{}
+		for i := range all {
+			if filter.Match(&all[i]) {
+				torrents = append(torrents, all[i])
+			}
+		}
+
+		sortTorrents(torrents, sortBy, desc)
+
+		list := TorrentList{Total: len(torrents), Offset: offset, Limit: limit}
+		if offset > len(torrents) {
+			offset = len(torrents)
+		}
+		torrents = torrents[offset:]
+		if limit > 0 && limit < len(torrents) {
+			torrents = torrents[:limit]
+		}
+		list.Torrents = torrents
+
+		writeJSON(w, list)
+	})
+}
+
+// TorrentHandler returns the torrent whose hash follows the /api/torrents/ path
+func (a *API) TorrentHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimPrefix(r.URL.Path, "/api/torrents/")
+		if hash == "" || strings.Contains(hash, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		torrents, err := a.cache.Torrents()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		for _, t := range torrents {
+			if strings.EqualFold(t.HashString, hash) {
+				writeJSON(w, t)
+				return
+			}
+		}
+		http.Error(w, "torrent not found", http.StatusNotFound)
+	})
+}
+
+// SessionHandler returns the daemon's session
+func (a *API) SessionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := a.client.GetSession()
+		if err != nil {
+			a.logger.Error("Failed to get session.", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, session)
+	})
+}
+
+// StatsHandler returns the daemon's session statistics
+func (a *API) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := a.client.GetSessionStats()
+		if err != nil {
+			a.logger.Error("Failed to get session stats.", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, stats)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestAPITorrents(t *testing.T) {
+	cache := newTestCache(t,
+		transmission.Torrent{ID: 1, Name: "debian.iso", Added: 300, UploadRatio: 1, Status: transmission.StatusSeed, Labels: []string{"linux"}, TotalSize: 100},
+		transmission.Torrent{ID: 2, Name: "arch.iso", Added: 100, UploadRatio: 2, Status: transmission.StatusDownload, Labels: []string{"linux"}, TotalSize: 200},
+		transmission.Torrent{ID: 3, Name: "movie.mkv", Added: 200, UploadRatio: 1, Status: transmission.StatusDownload, Labels: []string{"private"}, TotalSize: 300},
+		transmission.Torrent{ID: 4, Name: "cat.gif", Added: 400, UploadRatio: -1, Status: transmission.StatusStopped},
+	)
+	handler := NewAPI(zap.NewNop(), cache, cache.client).TorrentsHandler()
+
+	for _, test := range []struct {
+		query string
+		total int
+		ids   []int
+	}{
+		{"", 4, []int{1, 2, 3, 4}},
+		{"sort=name", 4, []int{2, 4, 1, 3}},
+		{"sort=date&order=desc", 4, []int{4, 1, 3, 2}},
+		// Torrents sorting equally are ordered by id, whatever the order.
+		{"sort=ratio", 4, []int{4, 1, 3, 2}},
+		{"sort=ratio&order=desc", 4, []int{2, 1, 3, 4}},
+		{"sort=id&order=asc", 4, []int{1, 2, 3, 4}},
+
+		{"offset=1&limit=2", 4, []int{2, 3}},
+		{"offset=3&limit=2", 4, []int{4}},
+		{"offset=10", 4, []int{}},
+		{"limit=0", 4, []int{1, 2, 3, 4}},
+		{"sort=name&order=desc&limit=1", 4, []int{3}},
+
+		{"status=download", 2, []int{2, 3}},
+		{"status=download&status=seed&exclude_label=private", 2, []int{1, 2}},
+		{"label=linux,private&name=%5C.iso$", 2, []int{1, 2}},
+		{"exclude_name=iso&min_size=1", 1, []int{3}},
+		{"exclude_status=stopped&sort=date&offset=1", 3, []int{3, 1}},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/torrents?"+test.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", test.query, rec.Code, rec.Body)
+			continue
+		}
+
+		var list TorrentList
+		if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		ids := make([]int, len(list.Torrents))
+		for i, torrent := range list.Torrents {
+			ids[i] = torrent.ID
+		}
+		if list.Total != test.total || fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("%s: got %d torrents %v, want %d torrents %v", test.query, list.Total, ids, test.total, test.ids)
+		}
+	}
+}
+
+func TestAPITorrentsInvalidParameters(t *testing.T) {
+	handler := NewAPI(zap.NewNop(), newTestCache(t), nil).TorrentsHandler()
+
+	for _, query := range []string{
+		"sort=size",
+		"order=up",
+		"offset=-1",
+		"offset=first",
+		"limit=-5",
+		"limit=10.5",
+		"min_size=1GB",
+		"status=seeding",
+		"exclude_status=paused",
+		"name=(",
+		"exclude_name=%5B",
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/torrents?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPITorrent(t *testing.T) {
+	cache := newTestCache(t, transmission.Torrent{ID: 1, HashString: "abcdef"})
+	handler := NewAPI(zap.NewNop(), cache, nil).TorrentHandler()
+
+	for path, status := range map[string]int{
+		"/api/torrents/abcdef":   http.StatusOK,
+		"/api/torrents/ABCDEF":   http.StatusOK,
+		"/api/torrents/012345":   http.StatusNotFound,
+		"/api/torrents/":         http.StatusNotFound,
+		"/api/torrents/abcdef/x": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, status)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	transmission "github.com/tobz/transmission-exporter"
+)
+
+// ListCmd prints the torrents matching the torrent filter flags
+type ListCmd struct {
+	Format string `arg:"--format" default:"table" help:"output format: table, json or csv"`
+	Sort   string `arg:"--sort" default:"id" help:"sort order: id, name, date or ratio"`
+	Desc   bool   `arg:"--desc" help:"sort in descending order"`
+	Limit  int    `arg:"--limit" help:"maximum number of torrents to print [default: all]"`
+}
+
+// SessionCmd prints the daemon's session
+type SessionCmd struct {
+	Format string `arg:"--format" default:"table" help:"output format: table or json"`
+}
+
+// StatsCmd prints the daemon's session statistics
+type StatsCmd struct {
+	Format string `arg:"--format" default:"table" help:"output format: table or json"`
+}
+
+// CheckCmd checks that Transmission can be queried with the configured address and credentials
+type CheckCmd struct{}
+
+// listFields are the torrent-get fields of the table and CSV columns
+var listFields = []string{"id", "name", "hashString", "status", "percentDone", "totalSize", "rateDownload", "rateUpload", "uploadRatio", "error"}
+
+// Run writes the matching torrents in the configured format
+func (lc *ListCmd) Run(w io.Writer, client *transmission.Client, filter *TorrentFilter) error {
+	fields := listFields
+	switch lc.Format {
+	case "json":
+		fields = transmission.AllTorrentFields
+	case "table", "csv":
+	default:
+		return fmt.Errorf("unknown format %q", lc.Format)
+	}
+
+	res, err := client.GetTorrents(false, append(filter.Fields(), fields...)...)
+	if err != nil {
+		return err
+	}
+	torrents := []transmission.Torrent{}
+	for i := range res.Torrents {
+		if filter.Match(&res.Torrents[i]) {
+			torrents = append(torrents, res.Torrents[i])
+		}
+	}
+	if err := sortTorrents(torrents, lc.Sort, lc.Desc); err != nil {
+		return err
+	}
+	if lc.Limit > 0 && lc.Limit < len(torrents) {
+		torrents = torrents[:lc.Limit]
+	}
+
+	switch lc.Format {
+	case "json":
+		return writeIndentedJSON(w, torrents)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "name", "hash", "status", "done", "size_bytes", "download_bytes", "upload_bytes", "ratio", "error"})
+		for _, t := range torrents {
+			cw.Write([]string{
+				strconv.Itoa(t.ID),
+				t.Name,
+				t.HashString,
+				transmission.StatusName(t.Status),
+				strconv.FormatFloat(t.PercentDone, 'f', -1, 64),
+				strconv.FormatInt(t.TotalSize, 10),
+				strconv.Itoa(t.RateDownload),
+				strconv.Itoa(t.RateUpload),
+				strconv.FormatFloat(t.UploadRatio, 'f', -1, 64),
+				transmission.ErrorName(t.Error),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tDONE\tSIZE\tDOWN\tUP\tRATIO\tERROR")
+		for _, t := range torrents {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%.1f%%\t%s\t%s/s\t%s/s\t%.2f\t%s\n",
+				t.ID,
+				t.Name,
+				transmission.StatusName(t.Status),
+				t.PercentDone*100,
+				formatBytes(t.TotalSize),
+				formatBytes(int64(t.RateDownload)),
+				formatBytes(int64(t.RateUpload)),
+				t.UploadRatio,
+				transmission.ErrorName(t.Error),
+			)
+		}
+		return tw.Flush()
+	}
+}
+
+// Run writes the session in the configured format
+func (sc *SessionCmd) Run(w io.Writer, client *transmission.Client) error {
+	if sc.Format != "table" && sc.Format != "json" {
+		return fmt.Errorf("unknown format %q", sc.Format)
+	}
+
+	session, err := client.GetSession()
+	if err != nil {
+		return err
+	}
+	if sc.Format == "json" {
+		return writeIndentedJSON(w, session)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Version\t%s\n", session.Version)
+	fmt.Fprintf(tw, "Download dir\t%s\n", session.DownloadDir)
+	fmt.Fprintf(tw, "Free space\t%s\n", formatBytes(session.DownloadDirFreeSpace))
+	fmt.Fprintf(tw, "Incomplete dir\t%s\n", session.IncompleteDir)
+	fmt.Fprintf(tw, "Download limit\t%s\n", formatLimit(session.SpeedLimitDownEnabled, session.SpeedLimitDown))
+	fmt.Fprintf(tw, "Upload limit\t%s\n", formatLimit(session.SpeedLimitUpEnabled, session.SpeedLimitUp))
+	fmt.Fprintf(tw, "Alt download limit\t%s\n", formatLimit(session.AltSpeedEnabled, session.AltSpeedDown))
+	fmt.Fprintf(tw, "Alt upload limit\t%s\n", formatLimit(session.AltSpeedEnabled, session.AltSpeedUp))
+	fmt.Fprintf(tw, "Seed ratio limit\t%s\n", formatOptional(session.SeedRatioLimited, strconv.FormatFloat(session.SeedRatioLimit, 'f', -1, 64)))
+	fmt.Fprintf(tw, "Download queue\t%s\n", formatOptional(session.DownloadQueueEnabled, strconv.Itoa(session.DownloadQueueSize)))
+	fmt.Fprintf(tw, "Seed queue\t%s\n", formatOptional(session.SeedQueueEnabled, strconv.Itoa(session.SeedQueueSize)))
+	fmt.Fprintf(tw, "Peer limit\t%d\n", session.PeerLimitGlobal)
+	fmt.Fprintf(tw, "Peer limit per torrent\t%d\n", session.PeerLimitPerTorrent)
+	fmt.Fprintf(tw, "Cache size\t%d MB\n", session.CacheSizeMB)
+	return tw.Flush()
+}
+
+// Run writes the session statistics in the configured format
+func (sc *StatsCmd) Run(w io.Writer, client *transmission.Client) error {
+	if sc.Format != "table" && sc.Format != "json" {
+		return fmt.Errorf("unknown format %q", sc.Format)
+	}
+
+	stats, err := client.GetSessionStats()
+	if err != nil {
+		return err
+	}
+	if sc.Format == "json" {
+		return writeIndentedJSON(w, stats)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Download\t%s/s\n", formatBytes(stats.DownloadSpeed))
+	fmt.Fprintf(tw, "Upload\t%s/s\n", formatBytes(stats.UploadSpeed))
+	fmt.Fprintf(tw, "Torrents\t%d total, %d active, %d paused\n", stats.TorrentCount, stats.ActiveTorrentCount, stats.PausedTorrentCount)
+	fmt.Fprintln(tw, "\tCURRENT\tCUMULATIVE")
+	fmt.Fprintf(tw, "Downloaded\t%s\t%s\n", formatBytes(stats.CurrentStats.DownloadedBytes), formatBytes(stats.CumulativeStats.DownloadedBytes))
+	fmt.Fprintf(tw, "Uploaded\t%s\t%s\n", formatBytes(stats.CurrentStats.UploadedBytes), formatBytes(stats.CumulativeStats.UploadedBytes))
+	fmt.Fprintf(tw, "Files added\t%d\t%d\n", stats.CurrentStats.FilesAdded, stats.CumulativeStats.FilesAdded)
+	fmt.Fprintf(tw, "Active\t%s\t%s\n",
+		time.Duration(stats.CurrentStats.SecondsActive)*time.Second,
+		time.Duration(stats.CumulativeStats.SecondsActive)*time.Second,
+	)
+	fmt.Fprintf(tw, "Sessions\t%d\t%d\n", stats.CurrentStats.SessionCount, stats.CumulativeStats.SessionCount)
+	return tw.Flush()
+}
+
+// Run queries the session and the torrents, failing if either can't be fetched
+func (cc *CheckCmd) Run(w io.Writer, client *transmission.Client) error {
+	start := time.Now()
+	session, err := client.GetSession()
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.Version == "" {
+		return fmt.Errorf("%s doesn't look like Transmission's RPC endpoint", client.Addr())
+	}
+	latency := time.Since(start)
+
+	res, err := client.GetTorrents(false, "id")
+	if err != nil {
+		return fmt.Errorf("failed to get torrents: %w", err)
+	}
+
+	fmt.Fprintf(w, "OK: Transmission %s at %s with %d torrents, answering in %s\n",
+		session.Version, client.Addr(), len(res.Torrents), latency.Round(time.Millisecond))
+	return nil
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatLimit formats a speed limit in KB/s
+func formatLimit(enabled bool, limit int) string {
+	return formatOptional(enabled, strconv.Itoa(limit)+" KB/s")
+}
+
+// formatOptional formats a setting that can be turned off
+func formatOptional(enabled bool, value string) string {
+	if !enabled {
+		return "off"
+	}
+	return value
+}
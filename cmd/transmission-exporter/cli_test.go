@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// newTestDaemon returns a client to a local stand-in for Transmission, answering each RPC method
+// with the given arguments
+func newTestDaemon(t *testing.T, arguments map[string]string) *transmission.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Transmission-Session-Id", "token")
+		if r.ContentLength == 0 {
+			// The session id handshake
+			return
+		}
+		var req struct {
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		args, ok := arguments[req.Method]
+		if !ok {
+			w.Write([]byte(`{"result": "method not found"}`))
+			return
+		}
+		w.Write([]byte(`{"arguments": ` + args + `, "result": "success"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := transmission.New(zap.NewNop(), server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// testTorrents are the torrent-get arguments of a test daemon
+const testTorrents = `{"torrents": [
+	{"id": 1, "name": "debian.iso", "hashString": "aa", "status": 6, "percentDone": 1, "totalSize": 1073741824, "rateUpload": 2048, "uploadRatio": 1.5},
+	{"id": 2, "name": "movie, part 1.mkv", "hashString": "bb", "status": 4, "percentDone": 0.255, "totalSize": 1536, "rateDownload": 512, "error": 3}
+]}`
+
+func TestListCmd(t *testing.T) {
+	client := newTestDaemon(t, map[string]string{"torrent-get": testTorrents})
+	noFilter, err := NewTorrentFilter(TorrentFilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeding, err := NewTorrentFilter(TorrentFilterConfig{IncludeStatuses: []string{"seed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		cmd    ListCmd
+		filter *TorrentFilter
+		want   string
+	}{
+		{
+			ListCmd{Format: "table", Sort: "id"},
+			noFilter,
+			"ID  NAME               STATUS    DONE    SIZE     DOWN     UP         RATIO  ERROR\n" +
+				"1   debian.iso         seed      100.0%  1.0 GiB  0 B/s    2.0 KiB/s  1.50   none\n" +
+				"2   movie, part 1.mkv  download  25.5%   1.5 KiB  512 B/s  0 B/s      0.00   local_error\n",
+		},
+		{
+			ListCmd{Format: "csv", Sort: "name", Desc: true},
+			noFilter,
+			"id,name,hash,status,done,size_bytes,download_bytes,upload_bytes,ratio,error\n" +
+				`2,"movie, part 1.mkv",bb,download,0.255,1536,512,0,0,local_error` + "\n" +
+				"1,debian.iso,aa,seed,1,1073741824,0,2048,1.5,none\n",
+		},
+		{
+			ListCmd{Format: "csv", Sort: "ratio", Desc: true, Limit: 1},
+			noFilter,
+			"id,name,hash,status,done,size_bytes,download_bytes,upload_bytes,ratio,error\n" +
+				"1,debian.iso,aa,seed,1,1073741824,0,2048,1.5,none\n",
+		},
+		{
+			ListCmd{Format: "table", Sort: "id"},
+			seeding,
+			"ID  NAME        STATUS  DONE    SIZE     DOWN   UP         RATIO  ERROR\n" +
+				"1   debian.iso  seed    100.0%  1.0 GiB  0 B/s  2.0 KiB/s  1.50   none\n",
+		},
+	} {
+		var out bytes.Buffer
+		if err := test.cmd.Run(&out, client, test.filter); err != nil {
+			t.Fatalf("%+v: %v", test.cmd, err)
+		}
+		if out.String() != test.want {
+			t.Errorf("%+v: got\n%s\nwant\n%s", test.cmd, out.String(), test.want)
+		}
+	}
+}
+
+func TestListCmdJSON(t *testing.T) {
+	client := newTestDaemon(t, map[string]string{"torrent-get": testTorrents})
+	filter, err := NewTorrentFilter(TorrentFilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := ListCmd{Format: "json", Sort: "name"}
+	if err := cmd.Run(&out, client, filter); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "[\n  {\n") {
+		t.Errorf("got unindented JSON %s", out.String())
+	}
+	var torrents []transmission.Torrent
+	if err := json.Unmarshal(out.Bytes(), &torrents); err != nil {
+		t.Fatal(err)
+	}
+	if len(torrents) != 2 || torrents[0].HashString != "aa" || torrents[1].Name != "movie, part 1.mkv" || torrents[1].PercentDone != 0.255 {
+		t.Errorf("got torrents %+v", torrents)
+	}
+
+	// An empty list is still a JSON array.
+	out.Reset()
+	none, err := NewTorrentFilter(TorrentFilterConfig{IncludeStatuses: []string{"check"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(&out, client, none); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[]\n" {
+		t.Errorf("got %q, want an empty array", out.String())
+	}
+}
+
+func TestListCmdErrors(t *testing.T) {
+	client := newTestDaemon(t, map[string]string{"torrent-get": testTorrents})
+	filter, err := NewTorrentFilter(TorrentFilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []ListCmd{
+		{Format: "yaml", Sort: "id"},
+		{Format: "table", Sort: "size"},
+	} {
+		if err := cmd.Run(&bytes.Buffer{}, client, filter); err == nil {
+			t.Errorf("%+v: got no error", cmd)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/slices"
+)
+
+// DashboardCmd prints a Grafana dashboard for the metrics exported with the given configuration
+type DashboardCmd struct {
+	Title       string `arg:"--title" default:"Transmission" help:"title of the dashboard"`
+	UID         string `arg:"--uid" default:"transmission" help:"uid of the dashboard"`
+	TopK        int    `arg:"--top-k" default:"10" help:"number of torrents graphed in per-torrent panels"`
+	LegendLabel string `arg:"--legend-label" default:"name" help:"torrent label identifying torrents in per-torrent panels"`
+}
+
+// dashboardRows are the titles of the dashboard rows, by collector, in the order they are shown
+var dashboardRows = []struct {
+	collector string
+	title     string
+}{
+	{"session", "Session"},
+	{"session_stats", "Session statistics"},
+	{"torrent", "Torrents"},
+	{"torrent_aggregate_download_dir", "Torrents by download dir"},
+	{"torrent_aggregate_label", "Torrents by label"},
+	{"torrent_aggregate_tracker", "Torrents by tracker"},
+	{"torrent_error", "Torrent errors"},
+	{"torrent_forecast", "Completion forecast"},
+	{"torrent_stuck", "Stuck torrents"},
+	{"torrent_events", "Torrent events"},
+	{"webhooks", "Webhooks"},
+	{"rpc", "Transmission RPC"},
+}
+
+// dashboardPanel is a Grafana panel, either a row or a time series
+type dashboardPanel struct {
+	ID          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Collapsed   *bool                  `json:"collapsed,omitempty"`
+	Datasource  *dashboardRef          `json:"datasource,omitempty"`
+	GridPos     dashboardGridPos       `json:"gridPos"`
+	FieldConfig *dashboardFields       `json:"fieldConfig,omitempty"`
+	Targets     []dashboardTarget      `json:"targets,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+}
+
+type dashboardRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type dashboardGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type dashboardFields struct {
+	Defaults  map[string]interface{} `json:"defaults"`
+	Overrides []interface{}          `json:"overrides"`
+}
+
+type dashboardTarget struct {
+	RefID        string        `json:"refId"`
+	Datasource   *dashboardRef `json:"datasource"`
+	Expr         string        `json:"expr"`
+	LegendFormat string        `json:"legendFormat"`
+}
+
+// exportedMetrics returns the registered metrics that are exported with the given configuration,
+// whose torrent labels and groupings must have been validated and metrics configured
+func exportedMetrics(conf *Config) []*Metric {
+	collectors := map[string]bool{
+		"session":          true,
+		"session_stats":    true,
+		"torrent":          !conf.NoTorrentMetrics,
+		"torrent_info":     !conf.NoTorrentMetrics && conf.TorrentInfo,
+		"torrent_error":    true,
+		"torrent_forecast": true,
+		"torrent_stuck":    true,
+		"torrent_events":   true,
+		"webhooks":         conf.WebhooksConfig != "",
+		"rpc":              true,
+	}
+	for _, g := range conf.AggregateBy {
+		collectors["torrent_aggregate_"+g] = true
+	}
+
+	var metrics []*Metric
+	for _, m := range metricRegistry {
+		if collectors[m.Collector] && m.Enabled() && !(m.PerTorrent && conf.NoTorrentMetrics) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// Dashboard returns the Grafana dashboard model with a panel for every given metric
+func (dc *DashboardCmd) Dashboard(metrics []*Metric, conf *Config) map[string]interface{} {
+	datasource := &dashboardRef{Type: "prometheus", UID: "${datasource}"}
+
+	var panels []dashboardPanel
+	id, y := 0, 0
+	for _, row := range dashboardRows {
+		var rowMetrics []*Metric
+		for _, m := range metrics {
+			if m.Collector == row.collector && !m.NoPanel {
+				rowMetrics = append(rowMetrics, m)
+			}
+		}
+		if len(rowMetrics) == 0 {
+			continue
+		}
+
+		id++
+		collapsed := false
+		panels = append(panels, dashboardPanel{
+			ID:        id,
+			Type:      "row",
+			Title:     row.title,
+			Collapsed: &collapsed,
+			GridPos:   dashboardGridPos{H: 1, W: 24, X: 0, Y: y},
+		})
+		y++
+
+		for i, m := range rowMetrics {
+			expr, legend := dc.query(m, conf)
+
+			id++
+			panels = append(panels, dashboardPanel{
+				ID:          id,
+				Type:        "timeseries",
+				Title:       m.FQName(),
+				Description: m.Help,
+				Datasource:  datasource,
+				GridPos:     dashboardGridPos{H: 8, W: 12, X: 12 * (i % 2), Y: y + 8*(i/2)},
+				FieldConfig: &dashboardFields{
+					Defaults:  map[string]interface{}{"unit": dashboardUnit(m)},
+					Overrides: []interface{}{},
+				},
+				Options: map[string]interface{}{
+					"legend":  map[string]interface{}{"displayMode": "list", "placement": "bottom", "showLegend": true},
+					"tooltip": map[string]interface{}{"mode": "multi", "sort": "desc"},
+				},
+				Targets: []dashboardTarget{{
+					RefID:        "A",
+					Datasource:   datasource,
+					Expr:         expr,
+					LegendFormat: legend,
+				}},
+			})
+		}
+		y += 8 * ((len(rowMetrics) + 1) / 2)
+	}
+
+	return map[string]interface{}{
+		"uid":           dc.UID,
+		"title":         dc.Title,
+		"tags":          []string{"transmission"},
+		"editable":      true,
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]interface{}{"from": "now-6h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				map[string]interface{}{
+					"name":       "instance",
+					"label":      "Instance",
+					"type":       "query",
+					"datasource": datasource,
+					"query":      fmt.Sprintf("label_values(%s, instance)", sessionUp.FQName()),
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+// query returns the PromQL expression and legend format graphing a metric
+func (dc *DashboardCmd) query(m *Metric, conf *Config) (string, string) {
+	expr := fmt.Sprintf(`%s{instance=~"$instance"}`, m.FQName())
+	switch {
+	case m.Buckets != nil:
+		// Histograms are graphed by their 90th percentile.
+		by := strings.Join(append([]string{"instance", "le"}, m.Labels...), ", ")
+		expr = fmt.Sprintf(`histogram_quantile(0.9, sum by (%s) (rate(%s_bucket{instance=~"$instance"}[$__rate_interval])))`, by, m.FQName())
+	case m.Type == prometheus.CounterValue:
+		expr = fmt.Sprintf("rate(%s[$__rate_interval])", expr)
+	}
+
+	var legend []string
+	if m.PerTorrent {
+		// Without the legend label on the metric itself, it is joined from the info metric if
+		// possible, or else torrents are told apart by their first label.
+		label := dc.LegendLabel
+		switch {
+		case slices.Contains(conf.TorrentLabels, label):
+		case conf.TorrentInfo && slices.Contains(torrentInfoLabels, label):
+			expr = fmt.Sprintf("%s * on(instance, hash) group_left(%s) %s", expr, label, torrentInfo.FQName())
+		default:
+			label = conf.TorrentLabels[0]
+		}
+		expr = fmt.Sprintf("topk(%d, %s)", dc.TopK, expr)
+		legend = append(legend, "{{"+label+"}}")
+	}
+	for _, l := range m.Labels {
+		legend = append(legend, "{{"+l+"}}")
+	}
+	if len(legend) == 0 {
+		legend = append(legend, "{{instance}}")
+	}
+
+	// Grafana expects dates in milliseconds.
+	if strings.HasPrefix(dashboardUnit(m), "dateTime") {
+		expr = fmt.Sprintf("%s * 1000", expr)
+	}
+
+	return expr, strings.Join(legend, " ")
+}
+
+// dashboardUnit returns the Grafana unit of a metric, which is "short" for plain numbers
+func dashboardUnit(m *Metric) string {
+	if m.Unit == "" {
+		return "short"
+	}
+	return m.Unit
+}
+
+// Run writes the dashboard for the given configuration as JSON
+func (dc *DashboardCmd) Run(w io.Writer, conf *Config) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dc.Dashboard(exportedMetrics(conf), conf))
+}
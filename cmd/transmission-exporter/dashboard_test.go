@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/alexflint/go-arg"
+)
+
+// TestDashboardUpToDate checks that the committed dashboard is the one generated with the default
+// configuration, which make dashboards regenerates
+func TestDashboardUpToDate(t *testing.T) {
+	var conf Config
+	p, err := arg.NewParser(arg.Config{}, &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Parse([]string{"dashboard"}); err != nil {
+		t.Fatal(err)
+	}
+	// The defaults main fills in
+	conf.TorrentLabels = []string{"id", "name"}
+	if err := ConfigureMetrics(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := conf.Dashboard.Run(&out, &conf); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile("../../dashboards/transmission.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), committed) {
+		t.Error("dashboards/transmission.json is out of date, run make dashboards")
+	}
+}
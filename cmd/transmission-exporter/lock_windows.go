@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile creates the file at path exclusively, failing if it exists. The file is removed by the
+// returned function, so it is left behind if the process is killed.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return func() {
+		os.Remove(path)
+	}, nil
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	arg "github.com/alexflint/go-arg"
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	transmission "github.com/tobz/transmission-exporter"
 
@@ -14,19 +19,46 @@ import (
 
 // Config gets its content from env and passes it on to different packages
 type Config struct {
-	TransmissionAddr     string `arg:"-h,--transmission-addr,env:TRANSMISSION_ADDR" default:"http://localhost:9091/transmission"`
-	TransmissionUsername string `arg:"-P,--transmission-username,env:TRANSMISSION_USERNAME"`
-	TransmissionPassword string `arg:"-u,--transmission-password,env:TRANSMISSION_PASSWORD"`
-	MetricsListenAddr    string `arg:"-l,env:METRICS_LISTEN_ADDR" default:":19091"`
-	MetricsPath          string `arg:"-p,env:METRICS_PATH" default:"/metrics"`
+	TransmissionAddr     string        `arg:"-h,--transmission-addr,env:TRANSMISSION_ADDR" default:"http://localhost:9091/transmission" help:"address of Transmission's RPC server, or of its Unix socket and RPC path like unix:/run/transmission/rpc.sock:/transmission"`
+	TransmissionUsername string        `arg:"-P,--transmission-username,env:TRANSMISSION_USERNAME"`
+	TransmissionPassword string        `arg:"-u,--transmission-password,env:TRANSMISSION_PASSWORD"`
+	MetricsListenAddr    string        `arg:"-l,env:METRICS_LISTEN_ADDR" default:":19091"`
+	MetricsPath          string        `arg:"-p,env:METRICS_PATH" default:"/metrics"`
+	TorrentLabels        []string      `arg:"--torrent-labels,env:TORRENT_LABELS" help:"labels attached to torrent metrics: id, name, hash, download_dir, labels, tracker, is_private, queue_position, creator, comment_host, magnet_link [default: id,name, or hash with --torrent-info]"`
+	TorrentInfo          bool          `arg:"--torrent-info,env:TORRENT_INFO" help:"export torrent metadata in transmission_torrent_info, labeling the other torrent metrics by hash only"`
+	EnableMetric         []string      `arg:"--enable-metric,env:ENABLE_METRICS" help:"metrics to export, without the transmission_ prefix [default: all]"`
+	DisableMetric        []string      `arg:"--disable-metric,env:DISABLE_METRICS" help:"metrics not to export, without the transmission_ prefix"`
+	NoTorrentMetrics     bool          `arg:"--no-torrent-metrics,env:NO_TORRENT_METRICS" help:"don't export per-torrent metrics"`
+	AggregateBy          []string      `arg:"--aggregate-by,env:AGGREGATE_BY" help:"export torrent metrics aggregated by download_dir, label and/or tracker"`
+	StuckAfter           time.Duration `arg:"--stuck-after,env:STUCK_AFTER" default:"1h" help:"time after which a torrent without download progress, without metadata or waiting to be checked is considered stuck"`
+	PollInterval         time.Duration `arg:"--poll-interval,env:POLL_INTERVAL" help:"also poll torrents at this interval instead of only when scraped, so events are detected without scrapes"`
+	EventHistory         int           `arg:"--event-history,env:EVENT_HISTORY" default:"1000" help:"number of torrent events kept in memory"`
+	WebhooksConfig       string        `arg:"--webhooks-config,env:WEBHOOKS_CONFIG" help:"JSON file configuring webhooks to post torrent events to"`
+	ForecastWindow       time.Duration `arg:"--forecast-window,env:FORECAST_WINDOW" default:"10m" help:"window of download rates the completion forecast of download dirs is based on"`
+	Namespace            string        `arg:"--namespace,env:NAMESPACE" default:"transmission" help:"prefix of the exporter's metric names"`
+	ConstLabels          []string      `arg:"--const-label,env:CONST_LABELS" help:"labels attached to every metric, as name=value, e.g. site=ams"`
+	NoMetricsEndpoint    bool          `arg:"--no-metrics-endpoint,env:NO_METRICS_ENDPOINT" help:"don't serve the metrics path, e.g. when only pushing metrics"`
+	StatusTopTorrents    int           `arg:"--status-top-torrents,env:STATUS_TOP_TORRENTS" default:"10" help:"number of torrents listed by rate and ratio on the status page"`
+	API                  bool          `arg:"--api,env:API" help:"serve torrents, the session and its stats as JSON under /api, which requests all torrent fields from Transmission"`
+
+	TorrentFilterConfig
+	OTLPConfig
+	PushConfig
+	SinkConfig
+	TextfileConfig
+
+	Rules     *RulesCmd     `arg:"subcommand:rules" help:"print Prometheus recording and alerting rules for the exporter's metrics"`
+	Dashboard *DashboardCmd `arg:"subcommand:dashboard" help:"print a Grafana dashboard for the metrics exported with the given configuration"`
+	List      *ListCmd      `arg:"subcommand:list" help:"print the torrents matching the torrent filter"`
+	Session   *SessionCmd   `arg:"subcommand:session" help:"print the session of Transmission"`
+	Stats     *StatsCmd     `arg:"subcommand:stats" help:"print the session statistics of Transmission"`
+	Check     *CheckCmd     `arg:"subcommand:check" help:"check that Transmission can be queried, exiting with a non-zero status if not"`
 }
 
 func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	logger.Info("Starting transmission-exporter.")
-
 	// Try loading any env files first, which populates $ENV before loading the configuration.
 	err := godotenv.Load()
 	if err != nil {
@@ -38,6 +70,51 @@ func main() {
 	if err = arg.Parse(&conf); err != nil {
 		logger.Fatal("Failed to parse command-line arguments.", zap.Error(err))
 	}
+	if err = SetNamespace(conf.Namespace); err != nil {
+		logger.Fatal("Invalid namespace.", zap.Error(err))
+	}
+
+	switch {
+	case conf.Rules != nil:
+		if err = conf.Rules.Run(os.Stdout); err != nil {
+			logger.Fatal("Failed to write rules.", zap.Error(err))
+		}
+		return
+	}
+
+	// With the info metric enabled, the other torrent metrics only need the hash to be joined with it.
+	conf.TorrentLabels = SplitList(conf.TorrentLabels)
+	if len(conf.TorrentLabels) == 0 {
+		if conf.TorrentInfo {
+			conf.TorrentLabels = []string{"hash"}
+		} else {
+			conf.TorrentLabels = []string{"id", "name"}
+		}
+	}
+	if err = ValidateTorrentLabels(conf.TorrentLabels, conf.TorrentInfo); err != nil {
+		logger.Fatal("Invalid torrent labels.", zap.Error(err))
+	}
+	conf.EnableMetric = SplitList(conf.EnableMetric)
+	conf.DisableMetric = SplitList(conf.DisableMetric)
+	if err = ConfigureMetrics(conf.EnableMetric, conf.DisableMetric); err != nil {
+		logger.Fatal("Invalid metrics.", zap.Error(err))
+	}
+	constLabels, err := ParseConstLabels(SplitList(conf.ConstLabels))
+	if err != nil {
+		logger.Fatal("Invalid constant labels.", zap.Error(err))
+	}
+	conf.AggregateBy = SplitList(conf.AggregateBy)
+	if err = ValidateTorrentGroupings(conf.AggregateBy); err != nil {
+		logger.Fatal("Invalid torrent aggregation.", zap.Error(err))
+	}
+
+	switch {
+	case conf.Dashboard != nil:
+		if err = conf.Dashboard.Run(os.Stdout, &conf); err != nil {
+			logger.Fatal("Failed to write dashboard.", zap.Error(err))
+		}
+		return
+	}
 
 	// Configure and construct our Transmission client.
 	var user *transmission.User
@@ -48,30 +125,157 @@ func main() {
 		}
 	}
 
+	filter, err := NewTorrentFilter(conf.TorrentFilterConfig)
+	if err != nil {
+		logger.Fatal("Invalid torrent filter.", zap.Error(err))
+	}
+
 	client, err := transmission.New(logger, conf.TransmissionAddr, user)
 	if err != nil {
-		logger.Error("Failed to construct Transmission client.", zap.Error(err))
+		logger.Fatal("Failed to construct Transmission client.", zap.Error(err))
+	}
 
+	switch {
+	case conf.List != nil:
+		if err = conf.List.Run(os.Stdout, client, filter); err != nil {
+			logger.Fatal("Failed to list torrents.", zap.Error(err))
+		}
+		return
+	case conf.Session != nil:
+		if err = conf.Session.Run(os.Stdout, client); err != nil {
+			logger.Fatal("Failed to get session.", zap.Error(err))
+		}
+		return
+	case conf.Stats != nil:
+		if err = conf.Stats.Run(os.Stdout, client); err != nil {
+			logger.Fatal("Failed to get session stats.", zap.Error(err))
+		}
+		return
+	case conf.Check != nil:
+		if err = conf.Check.Run(os.Stdout, client); err != nil {
+			logger.Fatal("Check failed.", zap.Error(err))
+		}
+		return
 	}
 
+	logger.Info("Starting transmission-exporter.")
+
 	// Wire up the Prometheus SDK to our various collectors, and serve the metrics endpoint over HTTP.
-	prometheus.MustRegister(NewTorrentCollector(logger, client))
-	prometheus.MustRegister(NewSessionCollector(logger, client))
-	prometheus.MustRegister(NewSessionStatsCollector(logger, client))
+	// The torrent collectors share a cache so that torrents are only fetched once per scrape.
+	// Everything is registered with the constant labels, including the Go and process metrics, which
+	// are left out of one-shot runs as they only describe the short-lived exporter process.
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(constLabels, registry)
+	if !conf.Once {
+		registerer.MustRegister(collectors.NewGoCollector())
+		registerer.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	rpcCollector := NewRPCCollector()
+	client.Observer = rpcCollector
+	registerer.MustRegister(rpcCollector)
+
+	torrentCache := NewTorrentCache(logger, client, filter)
+
+	// The API and the status page add the torrent fields they show to the cache, so they are set up
+	// before anything fetches torrents.
+	var links []StatusLink
+	if !conf.NoMetricsEndpoint {
+		links = append(links, StatusLink{"Metrics", conf.MetricsPath})
+	}
+	if conf.API {
+		links = append(links, StatusLink{"Torrents", "/api/torrents"}, StatusLink{"Session", "/api/session"}, StatusLink{"Stats", "/api/stats"})
+		api := NewAPI(logger, torrentCache, client)
+		http.Handle("/api/torrents", api.TorrentsHandler())
+		http.Handle("/api/torrents/", api.TorrentHandler())
+		http.Handle("/api/session", api.SessionHandler())
+		http.Handle("/api/stats", api.StatsHandler())
+	}
+	links = append(links, StatusLink{"Stuck torrents", "/api/stuck"}, StatusLink{"Events", "/api/events"})
+	status := NewStatusPage(logger, client, torrentCache, conf.StatusTopTorrents, links)
+	if !conf.NoTorrentMetrics {
+		registerer.MustRegister(NewTorrentCollector(logger, torrentCache, TorrentCollectorOptions{
+			Labels: conf.TorrentLabels,
+			Info:   conf.TorrentInfo,
+		}))
+	}
+	registerer.MustRegister(NewTorrentErrorCollector(logger, torrentCache))
+	registerer.MustRegister(NewTorrentForecastCollector(logger, torrentCache, conf.ForecastWindow))
+	stuckCollector := NewTorrentStuckCollector(logger, torrentCache, conf.StuckAfter, conf.TorrentLabels, !conf.NoTorrentMetrics)
+	registerer.MustRegister(stuckCollector)
+	events, err := NewTorrentEvents(logger, torrentCache, conf.EventHistory)
+	if err != nil {
+		logger.Fatal("Invalid event history.", zap.Error(err))
+	}
+	registerer.MustRegister(events)
+
+	if conf.WebhooksConfig != "" {
+		configs, err := LoadWebhookConfigs(conf.WebhooksConfig)
+		if err != nil {
+			logger.Fatal("Failed to load webhooks.", zap.Error(err))
+		}
+		webhooks, err := NewWebhooks(logger, events, configs)
+		if err != nil {
+			logger.Fatal("Invalid webhooks.", zap.Error(err))
+		}
+		registerer.MustRegister(webhooks)
+	}
+
+	if conf.PollInterval > 0 {
+		go torrentCache.Poll(conf.PollInterval)
+	}
+	if len(conf.AggregateBy) > 0 {
+		registerer.MustRegister(NewTorrentAggregateCollector(logger, torrentCache, conf.AggregateBy))
+	}
+	registerer.MustRegister(NewSessionCollector(logger, client))
+	registerer.MustRegister(NewSessionStatsCollector(logger, client))
+
+	if conf.Once {
+		code := RunOnce(logger, registry, torrentCache, conf.TextfileConfig)
+		logger.Sync()
+		os.Exit(code)
+	}
+
+	if conf.OTLPEndpoint != "" {
+		exporter, err := NewOTLPExporter(logger, registry, conf.OTLPConfig, conf.TransmissionAddr)
+		if err != nil {
+			logger.Fatal("Invalid OTLP configuration.", zap.Error(err))
+		}
+		go exporter.Run()
+	}
+	if conf.PushgatewayURL != "" {
+		pushgateway, err := NewPushgateway(logger, registry, conf.PushConfig)
+		if err != nil {
+			logger.Fatal("Invalid Pushgateway configuration.", zap.Error(err))
+		}
+		go pushgateway.Run()
+	}
+	if conf.RemoteWriteURL != "" {
+		remoteWriter, err := NewRemoteWriter(logger, registry, conf.PushConfig)
+		if err != nil {
+			logger.Fatal("Invalid remote-write configuration.", zap.Error(err))
+		}
+		go remoteWriter.Run()
+	}
+	sinks, err := NewSinks(logger, registry, conf.SinkConfig)
+	if err != nil {
+		logger.Fatal("Invalid sink configuration.", zap.Error(err))
+	}
+	if sinks != nil {
+		go sinks.Run()
+	}
 
 	http.Handle("/health/live", OkHandler())
 	http.Handle("/health/ready", OkHandler())
-	http.Handle(conf.MetricsPath, promhttp.Handler())
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>Transmission Exporter</title></head>
-			<body>
-			<h1>Transmission Exporter</h1>
-			<p><a href="` + conf.MetricsPath + `">Metrics</a></p>
-			</body>
-			</html>`))
-	})
+	if !conf.NoMetricsEndpoint {
+		http.Handle(conf.MetricsPath, status.Instrument(promhttp.InstrumentMetricHandler(
+			registerer, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		)))
+	}
+	http.Handle("/", status)
+	http.Handle("/api/stuck", stuckCollector)
+	http.Handle("/api/events", events.HistoryHandler())
+	http.Handle("/api/events/stream", events.StreamHandler())
 
 	err = http.ListenAndServe(conf.MetricsListenAddr, nil)
 	if err != nil {
@@ -86,6 +290,35 @@ func NumericBool(v bool) string {
 	return "0"
 }
 
+// SplitList flattens a list of arguments that may themselves be comma-separated lists
+func SplitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// ParsePairs parses a list of name=value pairs
+func ParsePairs(pairs []string) (map[string]string, error) {
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not of the form name=value", pair)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("duplicate name %q", name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
 func OkHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	})
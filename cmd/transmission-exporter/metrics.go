@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace prefixes the names of all metrics, and is set from the configuration at startup
+var namespace = "transmission_"
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Metric describes a metric exported by one of the collectors
+type Metric struct {
+	// Name is the name of the metric without the namespace
+	Name string
+	Help string
+	// Type is the type of the metric's values, unless it is a histogram
+	Type prometheus.ValueType
+	// Buckets are the upper bounds of the buckets of histograms, and are nil for other metrics
+	Buckets []float64
+	// Labels are the labels of the metric, on top of the configured torrent labels for
+	// per-torrent metrics
+	Labels []string
+	// Collector names the collector exporting the metric
+	Collector string
+	// Fields are the torrent-get fields needed for the metric, for metrics computed from torrents
+	Fields []string
+	// Unit is the Grafana unit of the metric's values, e.g. "bytes", "Bps" or "s"
+	Unit string
+	// PerTorrent marks metrics with a series per torrent
+	PerTorrent bool
+	// NoPanel marks metrics that aren't worth graphing, like info metrics
+	NoPanel bool
+	// Cumulative marks gauges of totals that only grow, like the bytes uploaded ever, which are
+	// exported as monotonic sums by sinks telling them apart from gauges
+	Cumulative bool
+
+	disabled bool
+}
+
+// metricRegistry contains all metrics exported by the collectors, in order of registration
+var metricRegistry []*Metric
+
+// registerMetric adds a metric to the registry
+func registerMetric(m *Metric) *Metric {
+	metricRegistry = append(metricRegistry, m)
+	return m
+}
+
+// FQName returns the fully-qualified name of the metric
+func (m *Metric) FQName() string {
+	return namespace + m.Name
+}
+
+// Desc returns the description of the metric, with the given labels in front of its own
+func (m *Metric) Desc(labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		m.FQName(),
+		m.Help,
+		append(append([]string(nil), labels...), m.Labels...),
+		nil,
+	)
+}
+
+// NewHistogramVec returns a histogram of the metric, for values observed as they happen rather than
+// read when collecting
+func (m *Metric) NewHistogramVec() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    m.FQName(),
+		Help:    m.Help,
+		Buckets: m.Buckets,
+	}, m.Labels)
+}
+
+// NewCounterVec returns a counter of the metric, for events counted as they happen. Counters
+// without labels are exported from the start.
+func (m *Metric) NewCounterVec() *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: m.FQName(),
+		Help: m.Help,
+	}, m.Labels)
+	if len(m.Labels) == 0 {
+		c.WithLabelValues()
+	}
+	return c
+}
+
+// Enabled reports whether the metric is exported
+func (m *Metric) Enabled() bool {
+	return !m.disabled
+}
+
+// lookupMetric returns the registered metric with the given name, without the namespace
+func lookupMetric(name string) *Metric {
+	for _, m := range metricRegistry {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// registeredMetric returns the registered metric with the given fully-qualified name, or nil for
+// other metrics
+func registeredMetric(fqName string) *Metric {
+	if !strings.HasPrefix(fqName, namespace) {
+		return nil
+	}
+	return lookupMetric(strings.TrimPrefix(fqName, namespace))
+}
+
+// SetNamespace sets the prefix of all metric names, which is separated from the names by an
+// underscore. It can't be empty, as the session collector's up metric would then clash with the up
+// series of Prometheus's scrapes.
+func SetNamespace(ns string) error {
+	if ns == "" {
+		return fmt.Errorf("the namespace can't be empty")
+	}
+	if !metricNameRegexp.MatchString(ns) {
+		return fmt.Errorf("invalid namespace %q", ns)
+	}
+	namespace = ns + "_"
+	return nil
+}
+
+// ParseConstLabels parses labels given as name=value pairs, rejecting names that are already used
+// by any of the metrics, by histograms and summaries, or as the target labels of pushed metrics
+func ParseConstLabels(pairs []string) (prometheus.Labels, error) {
+	used := map[string]bool{"le": true, "quantile": true, "job": true, "instance": true}
+	for name := range torrentLabels {
+		used[name] = true
+	}
+	for _, m := range metricRegistry {
+		for _, l := range m.Labels {
+			used[l] = true
+		}
+	}
+
+	labels, err := ParsePairs(pairs)
+	if err != nil {
+		return nil, err
+	}
+	for name := range labels {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid constant label name %q", name)
+		}
+		if used[name] {
+			return nil, fmt.Errorf("constant label %q is already used by metrics", name)
+		}
+	}
+	return labels, nil
+}
+
+// ConfigureMetrics exports only the metrics named in enable, or all of them if enable is empty,
+// except for those named in disable. Names are given without the namespace.
+func ConfigureMetrics(enable, disable []string) error {
+	enabled := make(map[*Metric]bool, len(enable))
+	for _, name := range enable {
+		m := lookupMetric(name)
+		if m == nil {
+			return fmt.Errorf("unknown metric %q", name)
+		}
+		enabled[m] = true
+	}
+	disabled := make(map[*Metric]bool, len(disable))
+	for _, name := range disable {
+		m := lookupMetric(name)
+		if m == nil {
+			return fmt.Errorf("unknown metric %q", name)
+		}
+		disabled[m] = true
+	}
+
+	for _, m := range metricRegistry {
+		m.disabled = (len(enabled) > 0 && !enabled[m]) || disabled[m]
+	}
+	return nil
+}
+
+// metricDescs holds the descriptions of the enabled metrics of a collector
+type metricDescs map[*Metric]*prometheus.Desc
+
+// newMetricDescs returns the descriptions of the enabled metrics of the named collector. Per-torrent
+// metrics get the given torrent labels in front of their own.
+func newMetricDescs(collector string, torrentLabels []string) metricDescs {
+	descs := make(metricDescs)
+	for _, m := range metricRegistry {
+		if m.Collector != collector || !m.Enabled() {
+			continue
+		}
+		if m.PerTorrent {
+			descs[m] = m.Desc(torrentLabels...)
+		} else {
+			descs[m] = m.Desc()
+		}
+	}
+	return descs
+}
+
+// Has reports whether the metric is enabled
+func (d metricDescs) Has(m *Metric) bool {
+	_, ok := d[m]
+	return ok
+}
+
+// Fields returns the torrent-get fields needed for the enabled metrics
+func (d metricDescs) Fields() []string {
+	var fields []string
+	for m := range d {
+		fields = append(fields, m.Fields...)
+	}
+	return fields
+}
+
+// Describe sends the descriptions of the enabled metrics
+func (d metricDescs) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range d {
+		ch <- desc
+	}
+}
+
+// Collect sends a sample of the metric if it is enabled
+func (d metricDescs) Collect(ch chan<- prometheus.Metric, m *Metric, value float64, labelValues ...string) {
+	if desc, ok := d[m]; ok {
+		ch <- prometheus.MustNewConstMetric(desc, m.Type, value, labelValues...)
+	}
+}
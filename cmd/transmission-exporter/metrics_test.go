@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSetNamespace(t *testing.T) {
+	t.Cleanup(func() { SetNamespace("transmission") })
+
+	for _, ns := range []string{"", "1st", "with-dash", "with space"} {
+		if err := SetNamespace(ns); err == nil {
+			t.Errorf("%q: got no error", ns)
+		}
+	}
+	if err := SetNamespace("seedbox"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionUp.FQName(); got != "seedbox_up" {
+		t.Errorf("got %s, want seedbox_up", got)
+	}
+}
+
+func TestParseConstLabels(t *testing.T) {
+	labels, err := ParseConstLabels([]string{"site=ams", "instance_name=seedbox1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels["site"] != "ams" || labels["instance_name"] != "seedbox1" {
+		t.Errorf("got labels %v", labels)
+	}
+
+	for _, pair := range []string{
+		// Labels of the exporter's metrics
+		"id=1",
+		"name=foo",
+		"method=get",
+		// Labels of histogram buckets and summary quantiles
+		"le=1",
+		"quantile=0.5",
+		// Target labels of remote-written and pushed metrics
+		"job=transmission",
+		"instance=seedbox1",
+		// Invalid names
+		"__reserved=x",
+		"1st=x",
+		"no value",
+	} {
+		if _, err := ParseConstLabels([]string{pair}); err == nil {
+			t.Errorf("%s: got no error", pair)
+		}
+	}
+}
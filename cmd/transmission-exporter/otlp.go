@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	transmission "github.com/tobz/transmission-exporter"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPConfig holds the configuration of an OTLPExporter. The environment variables are the ones of
+// the OpenTelemetry SDKs.
+type OTLPConfig struct {
+	OTLPEndpoint           string        `arg:"--otlp-endpoint,env:OTEL_EXPORTER_OTLP_ENDPOINT" help:"OpenTelemetry collector to push metrics to, e.g. http://localhost:4318, or localhost:4317 with --otlp-protocol grpc"`
+	OTLPProtocol           string        `arg:"--otlp-protocol,env:OTEL_EXPORTER_OTLP_PROTOCOL" default:"http/protobuf" help:"OTLP protocol: http/protobuf or grpc"`
+	OTLPHeaders            []string      `arg:"--otlp-header,env:OTEL_EXPORTER_OTLP_HEADERS" help:"headers sent with every push, as name=value"`
+	OTLPInsecure           bool          `arg:"--otlp-insecure,env:OTEL_EXPORTER_OTLP_INSECURE" help:"don't use TLS for OTLP/gRPC"`
+	OTLPInterval           time.Duration `arg:"--otlp-interval,env:OTLP_INTERVAL" default:"30s" help:"interval at which metrics are pushed"`
+	OTLPTimeout            time.Duration `arg:"--otlp-timeout,env:OTLP_TIMEOUT" default:"10s" help:"timeout of a push"`
+	OTLPResourceAttributes []string      `arg:"--otlp-resource-attribute,env:OTEL_RESOURCE_ATTRIBUTES" help:"resource attributes of the pushed metrics, as name=value"`
+}
+
+// otlpScope is the instrumentation scope of the pushed metrics
+const otlpScope = "github.com/tobz/transmission-exporter"
+
+// OTLPExporter periodically gathers the metrics of a registry and pushes them to an OpenTelemetry
+// collector over OTLP/HTTP or OTLP/gRPC
+type OTLPExporter struct {
+	logger   *zap.Logger
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	timeout  time.Duration
+
+	// series holds the last value and start time of every cumulative series, so that resets move
+	// the start time forward, and previous is the time of the previous push
+	series   map[string]otlpSeries
+	previous time.Time
+
+	// attributes are the resource attributes, to which the daemon's version is added on every push
+	attributes map[string]string
+
+	export func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+}
+
+// otlpSeries is the state of a cumulative series as of the previous push
+type otlpSeries struct {
+	value float64
+	start time.Time
+}
+
+// NewOTLPExporter creates an OTLPExporter pushing the metrics of the gatherer. The daemon address is
+// added to the resource attributes as server.address.
+func NewOTLPExporter(logger *zap.Logger, gatherer prometheus.Gatherer, conf OTLPConfig, transmissionAddr string) (*OTLPExporter, error) {
+	headers, err := ParsePairs(SplitList(conf.OTLPHeaders))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP headers: %w", err)
+	}
+	attributes, err := ParsePairs(SplitList(conf.OTLPResourceAttributes))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP resource attributes: %w", err)
+	}
+	if _, ok := attributes["service.name"]; !ok {
+		attributes["service.name"] = "transmission-exporter"
+	}
+	if _, ok := attributes["server.address"]; !ok {
+		if socket, _, ok := transmission.ParseUnixAddr(transmissionAddr); ok {
+			attributes["server.address"] = socket
+		} else if u, err := neturl.Parse(transmissionAddr); err == nil && u.Host != "" {
+			attributes["server.address"] = u.Host
+		}
+	}
+
+	e := &OTLPExporter{
+		logger:     logger,
+		gatherer:   gatherer,
+		interval:   conf.OTLPInterval,
+		timeout:    conf.OTLPTimeout,
+		series:     make(map[string]otlpSeries),
+		previous:   time.Now(),
+		attributes: attributes,
+	}
+
+	switch conf.OTLPProtocol {
+	case "http/protobuf", "http":
+		e.export, err = otlpHTTPExport(conf.OTLPEndpoint, headers)
+	case "grpc":
+		e.export, err = otlpGRPCExport(conf.OTLPEndpoint, headers, conf.OTLPInsecure)
+	default:
+		err = fmt.Errorf("unknown OTLP protocol %q", conf.OTLPProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// otlpHTTPExport returns a function posting requests to the /v1/metrics path of the endpoint
+func otlpHTTPExport(endpoint string, headers map[string]string) (func(context.Context, *colmetricspb.ExportMetricsServiceRequest) error, error) {
+	u, err := neturl.ParseRequestURI(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP/HTTP endpoint %q", endpoint)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/metrics"
+	url := u.String()
+
+	return func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		for k, v := range headers {
+			httpReq.Header.Set(k, v)
+		}
+
+		res, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, res.Body)
+
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil
+	}, nil
+}
+
+// otlpGRPCExport returns a function calling the metrics service at the endpoint, which is a host
+// and port optionally given as an http(s) URL
+func otlpGRPCExport(endpoint string, headers map[string]string, noTLS bool) (func(context.Context, *colmetricspb.ExportMetricsServiceRequest) error, error) {
+	target := endpoint
+	if u, err := neturl.Parse(endpoint); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		target = u.Host
+		noTLS = noTLS || u.Scheme == "http"
+	}
+	if target == "" {
+		return nil, fmt.Errorf("invalid OTLP/gRPC endpoint %q", endpoint)
+	}
+
+	creds := credentials.NewTLS(&tls.Config{})
+	if noTLS {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	client := colmetricspb.NewMetricsServiceClient(conn)
+
+	md := metadata.New(headers)
+	return func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+		_, err := client.Export(metadata.NewOutgoingContext(ctx, md), req)
+		return err
+	}, nil
+}
+
+// Run pushes the metrics at the configured interval, forever
+func (e *OTLPExporter) Run() {
+	for range time.Tick(e.interval) {
+		if err := e.Push(); err != nil {
+			e.logger.Error("Failed to push metrics over OTLP.", zap.Error(err))
+		}
+	}
+}
+
+// Push gathers the metrics and pushes them once
+func (e *OTLPExporter) Push() error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// Gathering errors are partial, so the metrics that could be gathered are still pushed.
+		e.logger.Warn("Failed to gather some metrics.", zap.Error(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	return e.export(ctx, e.request(families, time.Now()))
+}
+
+// request converts the gathered metric families to an OTLP export request
+func (e *OTLPExporter) request(families []*dto.MetricFamily, now time.Time) *colmetricspb.ExportMetricsServiceRequest {
+	attributes := make(map[string]string, len(e.attributes)+1)
+	for k, v := range e.attributes {
+		attributes[k] = v
+	}
+
+	// Series that are gone are forgotten, so that they start over if they come back.
+	series := make(map[string]otlpSeries, len(e.series))
+	var metrics []*metricspb.Metric
+	for _, f := range families {
+		if f.GetName() == sessionVersion.FQName() {
+			for _, m := range f.Metric {
+				for _, l := range m.Label {
+					if l.GetName() == "version" {
+						attributes["transmission.version"] = l.GetValue()
+					}
+				}
+			}
+		}
+
+		if m := e.metric(f, now, series); m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	e.series = series
+	e.previous = now
+
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: otlpAttributes(attributes)},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otlpScope},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// metric converts a metric family, with counters and cumulative gauges becoming cumulative monotonic
+// sums. The state of its cumulative series is added to series.
+func (e *OTLPExporter) metric(f *dto.MetricFamily, now time.Time, series map[string]otlpSeries) *metricspb.Metric {
+	ts := uint64(now.UnixNano())
+
+	m := &metricspb.Metric{
+		Name:        f.GetName(),
+		Description: f.GetHelp(),
+		Unit:        otlpUnit(f.GetName()),
+	}
+
+	cumulative := false
+	if rm := registeredMetric(f.GetName()); rm != nil {
+		cumulative = rm.Cumulative
+	}
+
+	switch {
+	case f.GetType() == dto.MetricType_COUNTER, f.GetType() == dto.MetricType_GAUGE && cumulative:
+		sum := &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}
+		for _, s := range f.Metric {
+			value := s.GetCounter().GetValue()
+			if f.GetType() == dto.MetricType_GAUGE {
+				value = s.GetGauge().GetValue()
+			}
+			sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:        otlpLabels(s.Label),
+				StartTimeUnixNano: e.startTime(series, f.GetName(), s.Label, value),
+				TimeUnixNano:      ts,
+				Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			})
+		}
+		m.Data = &metricspb.Metric_Sum{Sum: sum}
+
+	case f.GetType() == dto.MetricType_GAUGE, f.GetType() == dto.MetricType_UNTYPED:
+		gauge := &metricspb.Gauge{}
+		for _, s := range f.Metric {
+			value := s.GetGauge().GetValue()
+			if f.GetType() == dto.MetricType_UNTYPED {
+				value = s.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:   otlpLabels(s.Label),
+				TimeUnixNano: ts,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			})
+		}
+		m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+
+	case f.GetType() == dto.MetricType_HISTOGRAM:
+		histogram := &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}
+		for _, s := range f.Metric {
+			h := s.GetHistogram()
+			sum := h.GetSampleSum()
+			dp := &metricspb.HistogramDataPoint{
+				Attributes:        otlpLabels(s.Label),
+				StartTimeUnixNano: e.startTime(series, f.GetName(), s.Label, float64(h.GetSampleCount())),
+				TimeUnixNano:      ts,
+				Count:             h.GetSampleCount(),
+				Sum:               &sum,
+			}
+
+			// Prometheus buckets are cumulative, OTLP buckets aren't and end with an implicit +Inf.
+			var previous uint64
+			for _, b := range h.Bucket {
+				if math.IsInf(b.GetUpperBound(), 1) {
+					continue
+				}
+				dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+				dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-previous)
+				previous = b.GetCumulativeCount()
+			}
+			dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-previous)
+
+			histogram.DataPoints = append(histogram.DataPoints, dp)
+		}
+		m.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+
+	case f.GetType() == dto.MetricType_SUMMARY:
+		summary := &metricspb.Summary{}
+		for _, s := range f.Metric {
+			sm := s.GetSummary()
+			dp := &metricspb.SummaryDataPoint{
+				Attributes:        otlpLabels(s.Label),
+				StartTimeUnixNano: e.startTime(series, f.GetName(), s.Label, float64(sm.GetSampleCount())),
+				TimeUnixNano:      ts,
+				Count:             sm.GetSampleCount(),
+				Sum:               sm.GetSampleSum(),
+			}
+			for _, q := range sm.Quantile {
+				dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+					Quantile: q.GetQuantile(),
+					Value:    q.GetValue(),
+				})
+			}
+			summary.DataPoints = append(summary.DataPoints, dp)
+		}
+		m.Data = &metricspb.Metric_Summary{Summary: summary}
+
+	default:
+		return nil
+	}
+
+	return m
+}
+
+// startTime returns the start time of a cumulative series with the given value, and adds its state
+// to series. Series that are new or whose value went down, i.e. that were reset, start at the
+// previous push, and all others keep their start time.
+func (e *OTLPExporter) startTime(series map[string]otlpSeries, name string, labels []*dto.LabelPair, value float64) uint64 {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, l := range labels {
+		key.WriteString("\xff" + l.GetName() + "\xff" + l.GetValue())
+	}
+
+	s, ok := e.series[key.String()]
+	if !ok || value < s.value {
+		s.start = e.previous
+	}
+	s.value = value
+	series[key.String()] = s
+
+	return uint64(s.start.UnixNano())
+}
+
+// otlpUnits maps the Grafana units of registered metrics to UCUM units
+var otlpUnits = map[string]string{
+	"bytes":       "By",
+	"Bps":         "By/s",
+	"KBs":         "kBy/s",
+	"s":           "s",
+	"percentunit": "1",
+}
+
+// otlpUnit returns the unit of a registered metric, or an empty unit for other metrics
+func otlpUnit(name string) string {
+	if m := registeredMetric(name); m != nil {
+		return otlpUnits[m.Unit]
+	}
+	return ""
+}
+
+func otlpLabels(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attributes := make(map[string]string, len(labels))
+	for _, l := range labels {
+		attributes[l.GetName()] = l.GetValue()
+	}
+	return otlpAttributes(attributes)
+}
+
+func otlpAttributes(attributes map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(attributes))
+	for _, k := range keys {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attributes[k]}},
+		})
+	}
+	return kvs
+}
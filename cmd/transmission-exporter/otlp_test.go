@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector is a local stand-in for an OpenTelemetry collector receiving OTLP/HTTP pushes
+func otlpCollector(t *testing.T) (*httptest.Server, chan *colmetricspb.ExportMetricsServiceRequest) {
+	requests := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Errorf("got push to %s, want /v1/metrics", r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/x-protobuf" {
+			t.Errorf("got content type %q", got)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		req := &colmetricspb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("invalid export request: %v", err)
+		}
+		requests <- req
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestOTLPPush(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	uploaded := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: lookupMetric("torrent_uploaded_ever_bytes").FQName(),
+	}, []string{"id"})
+	uploaded.WithLabelValues("1").Set(1024)
+	ratio := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: lookupMetric("torrent_ratio").FQName(),
+	}, []string{"id"})
+	ratio.WithLabelValues("1").Set(0.5)
+	errors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: rpcErrors.FQName(),
+	}, []string{"method"})
+	errors.WithLabelValues("torrent-get").Add(2)
+	registry.MustRegister(uploaded, ratio, errors)
+
+	server, requests := otlpCollector(t)
+	e, err := NewOTLPExporter(zap.NewNop(), registry, OTLPConfig{
+		OTLPEndpoint:           server.URL,
+		OTLPProtocol:           "http/protobuf",
+		OTLPTimeout:            time.Second,
+		OTLPResourceAttributes: []string{"deployment.environment=test"},
+	}, "http://seedbox:9091/transmission/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Push(); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+
+	if len(req.ResourceMetrics) != 1 || len(req.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("got request %v", req)
+	}
+	resource := otlpAttributeMap(req.ResourceMetrics[0].Resource.Attributes)
+	for k, want := range map[string]string{
+		"service.name":           "transmission-exporter",
+		"server.address":         "seedbox:9091",
+		"deployment.environment": "test",
+	} {
+		if resource[k] != want {
+			t.Errorf("got resource attribute %s=%q, want %q", k, resource[k], want)
+		}
+	}
+
+	metrics := make(map[string]*metricspb.Metric)
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	// Cumulative gauges are monotonic sums like counters.
+	m := metrics["transmission_torrent_uploaded_ever_bytes"]
+	if sum := m.GetSum(); sum == nil || !sum.IsMonotonic ||
+		sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Errorf("got uploaded ever bytes %v, want a cumulative monotonic sum", m)
+	} else {
+		p := sum.DataPoints[0]
+		if p.GetAsDouble() != 1024 || p.StartTimeUnixNano == 0 || otlpAttributeMap(p.Attributes)["id"] != "1" {
+			t.Errorf("got data point %v", p)
+		}
+	}
+	if m.GetUnit() != "By" {
+		t.Errorf("got unit %q, want By", m.GetUnit())
+	}
+
+	m = metrics["transmission_rpc_errors_total"]
+	if sum := m.GetSum(); sum == nil || !sum.IsMonotonic || sum.DataPoints[0].GetAsDouble() != 2 {
+		t.Errorf("got rpc errors %v, want a monotonic sum of 2", m)
+	}
+
+	m = metrics["transmission_torrent_ratio"]
+	if gauge := m.GetGauge(); gauge == nil || gauge.DataPoints[0].GetAsDouble() != 0.5 {
+		t.Errorf("got ratio %v, want a gauge of 0.5", m)
+	}
+}
+
+func otlpAttributeMap(kvs []*commonpb.KeyValue) map[string]string {
+	attributes := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		attributes[kv.Key] = kv.Value.GetStringValue()
+	}
+	return attributes
+}
+
+func TestOTLPStartTimes(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	uploaded := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: lookupMetric("torrent_uploaded_ever_bytes").FQName(),
+	}, []string{"id"})
+	registry.MustRegister(uploaded)
+
+	e, err := NewOTLPExporter(zap.NewNop(), registry, OTLPConfig{
+		OTLPEndpoint: "http://localhost:4318",
+		OTLPProtocol: "http/protobuf",
+	}, "http://localhost:9091/transmission/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	e.previous = start
+	at := func(i int) uint64 { return uint64(start.Add(time.Duration(i) * time.Minute).UnixNano()) }
+
+	// Every step sets the values of the torrents and pushes a minute after the previous one,
+	// expecting the start times of the torrents by id.
+	for i, step := range []struct {
+		values map[string]float64
+		starts map[string]uint64
+	}{
+		{map[string]float64{"1": 5}, map[string]uint64{"1": at(0)}},
+		// New series start at the previous push.
+		{map[string]float64{"1": 7, "2": 1}, map[string]uint64{"1": at(0), "2": at(1)}},
+		// Series whose value went down were reset since the previous push.
+		{map[string]float64{"1": 2, "2": 3}, map[string]uint64{"1": at(2), "2": at(1)}},
+		{map[string]float64{"1": 2, "2": 3}, map[string]uint64{"1": at(2), "2": at(1)}},
+		{map[string]float64{"1": 4}, map[string]uint64{"1": at(2)}},
+		// Series that were gone start over.
+		{map[string]float64{"1": 4, "2": 3}, map[string]uint64{"1": at(2), "2": at(5)}},
+	} {
+		uploaded.Reset()
+		for id, v := range step.values {
+			uploaded.WithLabelValues(id).Set(v)
+		}
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := e.request(families, start.Add(time.Duration(i+1)*time.Minute))
+		starts := make(map[string]uint64)
+		for _, p := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetSum().GetDataPoints() {
+			starts[otlpAttributeMap(p.Attributes)["id"]] = p.StartTimeUnixNano
+		}
+		if !reflect.DeepEqual(starts, step.starts) {
+			t.Errorf("push %d: got start times %v, want %v", i+1, starts, step.starts)
+		}
+	}
+}
+
+// otlpGRPCCollector is a local stand-in for an OpenTelemetry collector receiving OTLP/gRPC pushes
+type otlpGRPCCollector struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	requests chan *colmetricspb.ExportMetricsServiceRequest
+	metadata chan metadata.MD
+}
+
+func (c *otlpGRPCCollector) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.metadata <- md
+	c.requests <- req
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPGRPCPush(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := &otlpGRPCCollector{
+		requests: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		metadata: make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, collector)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: rpcTokenRefreshes.FQName()}))
+
+	// An http URL means plaintext.
+	e, err := NewOTLPExporter(zap.NewNop(), registry, OTLPConfig{
+		OTLPEndpoint: "http://" + listener.Addr().String(),
+		OTLPProtocol: "grpc",
+		OTLPHeaders:  []string{"authorization=Bearer secret"},
+		OTLPTimeout:  5 * time.Second,
+	}, "http://localhost:9091/transmission/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	if md := <-collector.metadata; len(md.Get("authorization")) != 1 || md.Get("authorization")[0] != "Bearer secret" {
+		t.Errorf("got metadata %v", md)
+	}
+	req := <-collector.requests
+	if metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics; len(metrics) != 1 || metrics[0].Name != rpcTokenRefreshes.FQName() {
+		t.Errorf("got metrics %v", metrics)
+	}
+}
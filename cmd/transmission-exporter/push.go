@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+)
+
+// PushConfig holds the configuration of the Pushgateway and remote-write push modes
+type PushConfig struct {
+	PushInterval         time.Duration `arg:"--push-interval,env:PUSH_INTERVAL" default:"30s" help:"interval at which metrics are pushed to the Pushgateway or remote-write endpoint"`
+	PushJob              string        `arg:"--push-job,env:PUSH_JOB" default:"transmission" help:"job label of pushed metrics"`
+	PushgatewayURL       string        `arg:"--pushgateway-url,env:PUSHGATEWAY_URL" help:"Pushgateway to push metrics to, credentials may be given in the URL"`
+	PushgatewayGrouping  []string      `arg:"--pushgateway-grouping,env:PUSHGATEWAY_GROUPING" help:"grouping key of pushed metrics besides the job, as name=value, e.g. instance=seedbox1"`
+	PushgatewayHeaders   []string      `arg:"--pushgateway-header,env:PUSHGATEWAY_HEADERS" help:"headers sent with every push to the Pushgateway, as name=value"`
+	PushgatewayTimeout   time.Duration `arg:"--pushgateway-timeout,env:PUSHGATEWAY_TIMEOUT" default:"30s" help:"timeout of a push to the Pushgateway"`
+	RemoteWriteURL       string        `arg:"--remote-write-url,env:REMOTE_WRITE_URL" help:"Prometheus remote-write endpoint to send metrics to, credentials may be given in the URL"`
+	RemoteWriteHeaders   []string      `arg:"--remote-write-header,env:REMOTE_WRITE_HEADERS" help:"headers sent with every remote-write request, as name=value, e.g. Authorization=Bearer <token>"`
+	RemoteWriteInstance  string        `arg:"--remote-write-instance,env:REMOTE_WRITE_INSTANCE" help:"instance label of remote-written metrics [default: the hostname]"`
+	RemoteWriteQueueSize int           `arg:"--remote-write-queue-size,env:REMOTE_WRITE_QUEUE_SIZE" default:"10" help:"number of gathered snapshots queued while the remote-write endpoint is unavailable"`
+	RemoteWriteRetries   int           `arg:"--remote-write-retries,env:REMOTE_WRITE_RETRIES" default:"3" help:"number of retries of a failed remote-write request, with a doubling backoff"`
+	RemoteWriteTimeout   time.Duration `arg:"--remote-write-timeout,env:REMOTE_WRITE_TIMEOUT" default:"30s" help:"timeout of a remote-write request"`
+}
+
+// remoteWriteBackoff is the initial backoff of retried remote-write requests
+const remoteWriteBackoff = time.Second
+
+// headerClient is an http.Client that sets additional headers on every request
+type headerClient struct {
+	http.Client
+	headers map[string]string
+}
+
+// Do implements the push.HTTPDoer interface
+func (c *headerClient) Do(req *http.Request) (*http.Response, error) {
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	return c.Client.Do(req)
+}
+
+// Pushgateway periodically pushes the metrics of a registry to a Pushgateway, replacing the
+// metrics of its grouping key on every push
+type Pushgateway struct {
+	logger   *zap.Logger
+	pusher   *push.Pusher
+	interval time.Duration
+}
+
+// NewPushgateway creates a Pushgateway pushing the metrics of the gatherer
+func NewPushgateway(logger *zap.Logger, gatherer prometheus.Gatherer, conf PushConfig) (*Pushgateway, error) {
+	if _, err := neturl.ParseRequestURI(conf.PushgatewayURL); err != nil {
+		return nil, fmt.Errorf("invalid Pushgateway URL: %w", err)
+	}
+	grouping, err := ParsePairs(SplitList(conf.PushgatewayGrouping))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pushgateway grouping: %w", err)
+	}
+	headers, err := ParsePairs(SplitList(conf.PushgatewayHeaders))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pushgateway headers: %w", err)
+	}
+
+	pusher := push.New(conf.PushgatewayURL, conf.PushJob).
+		Gatherer(gatherer).
+		Client(&headerClient{Client: http.Client{Timeout: conf.PushgatewayTimeout}, headers: headers})
+	for k, v := range grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+	if err := pusher.Error(); err != nil {
+		return nil, err
+	}
+
+	return &Pushgateway{
+		logger:   logger,
+		pusher:   pusher,
+		interval: conf.PushInterval,
+	}, nil
+}
+
+// Run pushes the metrics at the configured interval, forever
+func (pg *Pushgateway) Run() {
+	for range time.Tick(pg.interval) {
+		if err := pg.pusher.Push(); err != nil {
+			pg.logger.Error("Failed to push metrics to the Pushgateway.", zap.Error(err))
+		}
+	}
+}
+
+// RemoteWriter periodically gathers the metrics of a registry and sends them to a Prometheus
+// remote-write endpoint. Snapshots are queued while the endpoint is unavailable, and dropped once
+// the queue is full.
+type RemoteWriter struct {
+	logger   *zap.Logger
+	gatherer prometheus.Gatherer
+	client   *headerClient
+	url      string
+	interval time.Duration
+	retries  int
+	backoff  time.Duration
+
+	// labels are added to every series, like the target labels of a scrape
+	labels map[string]string
+	queue  chan []byte
+}
+
+// NewRemoteWriter creates a RemoteWriter sending the metrics of the gatherer
+func NewRemoteWriter(logger *zap.Logger, gatherer prometheus.Gatherer, conf PushConfig) (*RemoteWriter, error) {
+	if _, err := neturl.ParseRequestURI(conf.RemoteWriteURL); err != nil {
+		return nil, fmt.Errorf("invalid remote-write URL: %w", err)
+	}
+	headers, err := ParsePairs(SplitList(conf.RemoteWriteHeaders))
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote-write headers: %w", err)
+	}
+	headers["Content-Encoding"] = "snappy"
+	headers["Content-Type"] = "application/x-protobuf"
+	headers["X-Prometheus-Remote-Write-Version"] = "0.1.0"
+
+	instance := conf.RemoteWriteInstance
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to get hostname for the instance label: %w", err)
+		}
+	}
+
+	return &RemoteWriter{
+		logger:   logger,
+		gatherer: gatherer,
+		client: &headerClient{
+			Client:  http.Client{Timeout: conf.RemoteWriteTimeout},
+			headers: headers,
+		},
+		url:      conf.RemoteWriteURL,
+		interval: conf.PushInterval,
+		retries:  conf.RemoteWriteRetries,
+		backoff:  remoteWriteBackoff,
+		labels:   map[string]string{"job": conf.PushJob, "instance": instance},
+		queue:    make(chan []byte, conf.RemoteWriteQueueSize),
+	}, nil
+}
+
+// Run gathers the metrics at the configured interval and sends them, forever
+func (rw *RemoteWriter) Run() {
+	go rw.send()
+
+	for range time.Tick(rw.interval) {
+		if err := rw.push(); err != nil {
+			rw.logger.Error("Failed to encode metrics for the remote-write endpoint.", zap.Error(err))
+		}
+	}
+}
+
+// push gathers the metrics and queues them to be sent, dropping them if the queue is full
+func (rw *RemoteWriter) push() error {
+	families, err := rw.gatherer.Gather()
+	if err != nil {
+		// Gathering errors are partial, so the metrics that could be gathered are still sent.
+		rw.logger.Warn("Failed to gather some metrics.", zap.Error(err))
+	}
+	req, err := rw.writeRequest(families, time.Now()).Marshal()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case rw.queue <- snappy.Encode(nil, req):
+	default:
+		rw.logger.Warn("Dropped metrics, remote-write queue is full.")
+	}
+	return nil
+}
+
+// send sends the queued requests one by one
+func (rw *RemoteWriter) send() {
+	for body := range rw.queue {
+		backoff := rw.backoff
+		for attempt := 0; ; attempt++ {
+			retry, err := rw.post(body)
+			if err == nil {
+				break
+			}
+			if !retry || attempt >= rw.retries {
+				rw.logger.Error("Failed to send metrics to the remote-write endpoint.", zap.Error(err))
+				break
+			}
+
+			rw.logger.Warn("Failed to send metrics to the remote-write endpoint, retrying.", zap.Duration("backoff", backoff), zap.Error(err))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// post sends a request, and reports whether it is worth retrying if it failed
+func (rw *RemoteWriter) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), "POST", rw.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	res, err := rw.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// Only server errors and throttling are temporary, the endpoint rejected anything else.
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unexpected status %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return false, nil
+}
+
+// writeRequest converts the metric families to a remote-write request, with a single sample per
+// series and the labels sorted by name as remote-write requires
+func (rw *RemoteWriter) writeRequest(families []*dto.MetricFamily, now time.Time) *prompb.WriteRequest {
+	ts := now.UnixMilli()
+
+	req := &prompb.WriteRequest{}
+	for _, s := range Samples(families) {
+		labels := make([]prompb.Label, 0, len(rw.labels)+len(s.Labels)+1)
+		for k, v := range rw.labels {
+			labels = append(labels, prompb.Label{Name: k, Value: v})
+		}
+		for k, v := range s.Labels {
+			labels = append(labels, prompb.Label{Name: k, Value: v})
+		}
+		labels = append(labels, prompb.Label{Name: "__name__", Value: s.Name})
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: s.Value, Timestamp: ts}},
+		})
+	}
+	return req
+}
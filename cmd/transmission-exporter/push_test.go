@@ -0,0 +1,282 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+)
+
+// pushRequest is a request received by a pushServer
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// pushServer is a local stand-in for a Pushgateway or remote-write endpoint, answering with the
+// given statuses in turn and then with 200
+type pushServer struct {
+	*httptest.Server
+
+	statuses []int
+	requests []pushRequest
+	lock     sync.Mutex
+}
+
+func newPushServer(t *testing.T, statuses ...int) *pushServer {
+	s := &pushServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests = append(s.requests, pushRequest{r.Method, r.URL.Path, r.Header, body})
+		if len(s.statuses) > 0 {
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pushServer) received() []pushRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]pushRequest(nil), s.requests...)
+}
+
+// testPushRegistry returns a registry with a gauge and a counter
+func testPushRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	ratio := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: lookupMetric("torrent_ratio").FQName(),
+		Help: "ratio",
+	}, []string{"name", "id"})
+	ratio.WithLabelValues("foo", "1").Set(1.5)
+	errors := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: rpcTokenRefreshes.FQName(),
+		Help: "refreshes",
+	})
+	errors.Add(3)
+	registry.MustRegister(ratio, errors)
+	return registry
+}
+
+func newTestRemoteWriter(t *testing.T, url string, queueSize int) *RemoteWriter {
+	rw, err := NewRemoteWriter(zap.NewNop(), testPushRegistry(), PushConfig{
+		PushJob:              "transmission",
+		RemoteWriteURL:       url,
+		RemoteWriteHeaders:   []string{"Authorization=Bearer secret"},
+		RemoteWriteInstance:  "seedbox",
+		RemoteWriteQueueSize: queueSize,
+		RemoteWriteRetries:   2,
+		RemoteWriteTimeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.backoff = time.Millisecond
+	return rw
+}
+
+// flush sends the queued requests and waits for them to be sent
+func flush(rw *RemoteWriter) {
+	close(rw.queue)
+	rw.send()
+}
+
+// decodeWriteRequest decodes the snappy-compressed remote-write request of a body
+func decodeWriteRequest(t *testing.T, body []byte) *prompb.WriteRequest {
+	t.Helper()
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	return &req
+}
+
+func TestRemoteWrite(t *testing.T) {
+	server := newPushServer(t)
+	rw := newTestRemoteWriter(t, server.URL, 10)
+	before := time.Now()
+	if err := rw.push(); err != nil {
+		t.Fatal(err)
+	}
+	flush(rw)
+
+	requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	for name, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer secret",
+	} {
+		if got := requests[0].header.Get(name); got != want {
+			t.Errorf("got header %s %q, want %q", name, got, want)
+		}
+	}
+
+	series := make(map[string]prompb.TimeSeries)
+	for _, ts := range decodeWriteRequest(t, requests[0].body).Timeseries {
+		names := make([]string, len(ts.Labels))
+		labels := make(map[string]string, len(ts.Labels))
+		for i, l := range ts.Labels {
+			names[i] = l.Name
+			labels[l.Name] = l.Value
+		}
+		if !sort.StringsAreSorted(names) {
+			t.Errorf("labels %v aren't sorted", names)
+		}
+		if labels["job"] != "transmission" || labels["instance"] != "seedbox" {
+			t.Errorf("got target labels %v", labels)
+		}
+		if len(ts.Samples) != 1 || ts.Samples[0].Timestamp < before.UnixMilli() {
+			t.Errorf("got samples %v", ts.Samples)
+		}
+		series[labels["__name__"]] = ts
+	}
+
+	ratio, ok := series["transmission_torrent_ratio"]
+	if !ok {
+		t.Fatalf("no ratio series in %v", series)
+	}
+	want := []prompb.Label{
+		{Name: "__name__", Value: "transmission_torrent_ratio"},
+		{Name: "id", Value: "1"},
+		{Name: "instance", Value: "seedbox"},
+		{Name: "job", Value: "transmission"},
+		{Name: "name", Value: "foo"},
+	}
+	if !reflect.DeepEqual(ratio.Labels, want) || ratio.Samples[0].Value != 1.5 {
+		t.Errorf("got ratio series %v", ratio)
+	}
+	if refreshes := series["transmission_rpc_token_refreshes_total"]; len(refreshes.Samples) != 1 || refreshes.Samples[0].Value != 3 {
+		t.Errorf("got token refreshes series %v", refreshes)
+	}
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	for _, test := range []struct {
+		statuses []int
+		requests int
+	}{
+		// Server errors and throttling are retried, up to 2 times.
+		{[]int{http.StatusServiceUnavailable}, 2},
+		{[]int{http.StatusTooManyRequests, http.StatusInternalServerError}, 3},
+		{[]int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 3},
+		// Other errors are rejections, and retrying wouldn't help.
+		{[]int{http.StatusBadRequest}, 1},
+		{[]int{http.StatusNotFound}, 1},
+	} {
+		server := newPushServer(t, test.statuses...)
+		rw := newTestRemoteWriter(t, server.URL, 10)
+		if err := rw.push(); err != nil {
+			t.Fatal(err)
+		}
+		flush(rw)
+
+		requests := server.received()
+		if len(requests) != test.requests {
+			t.Errorf("statuses %v: got %d requests, want %d", test.statuses, len(requests), test.requests)
+		}
+		for _, r := range requests[1:] {
+			if !reflect.DeepEqual(r.body, requests[0].body) {
+				t.Errorf("statuses %v: retried with another body", test.statuses)
+			}
+		}
+	}
+}
+
+func TestRemoteWriteQueueFull(t *testing.T) {
+	server := newPushServer(t)
+	rw := newTestRemoteWriter(t, server.URL, 1)
+
+	// Nothing is sent while queueing, so the second snapshot doesn't fit.
+	for i := 0; i < 2; i++ {
+		if err := rw.push(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush(rw)
+
+	if n := len(server.received()); n != 1 {
+		t.Errorf("got %d requests, want 1 with the second snapshot dropped", n)
+	}
+}
+
+func TestPushgateway(t *testing.T) {
+	server := newPushServer(t)
+	pg, err := NewPushgateway(zap.NewNop(), testPushRegistry(), PushConfig{
+		PushJob:             "transmission",
+		PushgatewayURL:      server.URL,
+		PushgatewayGrouping: []string{"instance=seedbox1,rack=a/b"},
+		PushgatewayHeaders:  []string{"X-Scope-OrgID=tenant"},
+		PushgatewayTimeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.pusher.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	r := requests[0]
+	if r.method != http.MethodPut {
+		t.Errorf("got method %s, want PUT replacing the group", r.method)
+	}
+	// The grouping labels follow the job in any order, with values containing slashes base64
+	// encoded.
+	grouping := make(map[string]string)
+	segments := strings.Split(strings.TrimPrefix(r.path, "/metrics/job/transmission/"), "/")
+	for i := 0; i+1 < len(segments); i += 2 {
+		grouping[segments[i]] = segments[i+1]
+	}
+	if want := map[string]string{"instance": "seedbox1", "rack@base64": "YS9i"}; len(segments)%2 != 0 || !reflect.DeepEqual(grouping, want) {
+		t.Errorf("got path %s, want the grouping %v", r.path, want)
+	}
+	if got := r.header.Get("X-Scope-OrgID"); got != "tenant" {
+		t.Errorf("got header X-Scope-OrgID %q", got)
+	}
+	if len(r.body) == 0 {
+		t.Error("got an empty push")
+	}
+}
+
+func TestPushConfigErrors(t *testing.T) {
+	for name, conf := range map[string]PushConfig{
+		"invalid URL":      {PushgatewayURL: "not a url"},
+		"invalid grouping": {PushgatewayURL: "http://example.org", PushgatewayGrouping: []string{"instance"}},
+		"invalid header":   {PushgatewayURL: "http://example.org", PushgatewayHeaders: []string{"X-Header"}},
+	} {
+		if _, err := NewPushgateway(zap.NewNop(), prometheus.NewRegistry(), conf); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+	if _, err := NewRemoteWriter(zap.NewNop(), prometheus.NewRegistry(), PushConfig{RemoteWriteURL: "not a url"}); err == nil {
+		t.Error("invalid remote-write URL: got no error")
+	}
+}
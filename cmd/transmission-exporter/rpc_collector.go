@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rpcDuration = registerMetric(&Metric{
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of the RPCs to Transmission by method, until the response is read",
+		Buckets:   prometheus.DefBuckets,
+		Labels:    []string{"method"},
+		Collector: "rpc",
+		Unit:      "s",
+	})
+	rpcResponseSize = registerMetric(&Metric{
+		Name:      "rpc_response_size_bytes",
+		Help:      "Size of the responses of the RPCs to Transmission by method",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 10),
+		Labels:    []string{"method"},
+		Collector: "rpc",
+		Unit:      "bytes",
+	})
+	rpcDecodeDuration = registerMetric(&Metric{
+		Name:      "rpc_decode_duration_seconds",
+		Help:      "Time spent decoding the responses of the RPCs to Transmission by method",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		Labels:    []string{"method"},
+		Collector: "rpc",
+		Unit:      "s",
+	})
+	rpcErrors = registerMetric(&Metric{
+		Name:      "rpc_errors_total",
+		Help:      "The number of RPCs to Transmission that failed without a usable response by method",
+		Type:      prometheus.CounterValue,
+		Labels:    []string{"method"},
+		Collector: "rpc",
+	})
+	rpcTokenRefreshes = registerMetric(&Metric{
+		Name:      "rpc_token_refreshes_total",
+		Help:      "The number of times Transmission asked for a new session id",
+		Type:      prometheus.CounterValue,
+		Collector: "rpc",
+	})
+	rpcUnauthorized = registerMetric(&Metric{
+		Name:      "rpc_unauthorized_total",
+		Help:      "The number of RPCs rejected by Transmission because of the credentials",
+		Type:      prometheus.CounterValue,
+		Collector: "rpc",
+	})
+)
+
+// RPCCollector observes the RPCs of the Transmission client and exports them as metrics
+type RPCCollector struct {
+	durations       *prometheus.HistogramVec
+	sizes           *prometheus.HistogramVec
+	decodeDurations *prometheus.HistogramVec
+	errors          *prometheus.CounterVec
+	tokenRefreshes  *prometheus.CounterVec
+	unauthorized    *prometheus.CounterVec
+
+	// collectors are the collectors of the enabled metrics
+	collectors []prometheus.Collector
+}
+
+// NewRPCCollector returns a collector to be set as the Observer of the Transmission client
+func NewRPCCollector() *RPCCollector {
+	rc := &RPCCollector{
+		durations:       rpcDuration.NewHistogramVec(),
+		sizes:           rpcResponseSize.NewHistogramVec(),
+		decodeDurations: rpcDecodeDuration.NewHistogramVec(),
+		errors:          rpcErrors.NewCounterVec(),
+		tokenRefreshes:  rpcTokenRefreshes.NewCounterVec(),
+		unauthorized:    rpcUnauthorized.NewCounterVec(),
+	}
+	for m, c := range map[*Metric]prometheus.Collector{
+		rpcDuration:       rc.durations,
+		rpcResponseSize:   rc.sizes,
+		rpcDecodeDuration: rc.decodeDurations,
+		rpcErrors:         rc.errors,
+		rpcTokenRefreshes: rc.tokenRefreshes,
+		rpcUnauthorized:   rc.unauthorized,
+	} {
+		if m.Enabled() {
+			rc.collectors = append(rc.collectors, c)
+		}
+	}
+	return rc
+}
+
+// ObserveRPC implements the transmission.Observer interface
+func (rc *RPCCollector) ObserveRPC(method string, duration time.Duration, responseSize int) {
+	rc.durations.WithLabelValues(method).Observe(duration.Seconds())
+	rc.sizes.WithLabelValues(method).Observe(float64(responseSize))
+}
+
+// ObserveError implements the transmission.Observer interface
+func (rc *RPCCollector) ObserveError(method string, err error) {
+	rc.errors.WithLabelValues(method).Inc()
+}
+
+// ObserveTokenRefresh implements the transmission.Observer interface
+func (rc *RPCCollector) ObserveTokenRefresh() {
+	rc.tokenRefreshes.WithLabelValues().Inc()
+}
+
+// ObserveUnauthorized implements the transmission.Observer interface
+func (rc *RPCCollector) ObserveUnauthorized() {
+	rc.unauthorized.WithLabelValues().Inc()
+}
+
+// ObserveDecode implements the transmission.Observer interface
+func (rc *RPCCollector) ObserveDecode(method string, duration time.Duration) {
+	rc.decodeDurations.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// Describe implements the prometheus.Collector interface
+func (rc *RPCCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range rc.collectors {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface
+func (rc *RPCCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range rc.collectors {
+		c.Collect(ch)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestRPCCollectorErrors(t *testing.T) {
+	responses := []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+		func(w http.ResponseWriter) { w.Write([]byte("<html>not JSON</html>")) },
+		func(w http.ResponseWriter) {
+			w.Write([]byte(`{"arguments": {"rpc-version": 17}, "result": "success"}`))
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Transmission-Session-Id", "token")
+		if r.ContentLength == 0 {
+			// The session id handshake
+			return
+		}
+		respond := responses[0]
+		responses = responses[1:]
+		respond(w)
+	}))
+	defer server.Close()
+
+	client, err := transmission.New(zap.NewNop(), server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRPCCollector()
+	client.Observer = rc
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetSession()
+		if (i < 2) != (err != nil) {
+			t.Errorf("request %d: got error %v", i, err)
+		}
+	}
+
+	expected := `
+# HELP transmission_rpc_errors_total The number of RPCs to Transmission that failed without a usable response by method
+# TYPE transmission_rpc_errors_total counter
+transmission_rpc_errors_total{method="session-get"} 2
+`
+	if err := testutil.CollectAndCompare(rc, strings.NewReader(expected), "transmission_rpc_errors_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(rc, "transmission_rpc_duration_seconds"); n != 2 {
+		t.Errorf("got durations of %d methods, want the handshake and session-get", n)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RulesCmd prints Prometheus recording and alerting rules for the exporter's metrics
+type RulesCmd struct {
+	Job           string        `arg:"--job" default:"transmission" help:"job label of the exporter's scrape targets"`
+	For           time.Duration `arg:"--for" default:"5m" help:"time a condition has to hold before alerts fire"`
+	FreeSpaceMin  int64         `arg:"--free-space-min" default:"10737418240" help:"alert when the free space of the download dir drops below this many bytes"`
+	ErroredMax    int           `arg:"--errored-max" default:"0" help:"alert when more than this many torrents have a local error"`
+	TrackerErrMax int           `arg:"--tracker-errors-max" default:"0" help:"alert when more than this many torrents have a tracker error"`
+	RatioGoal     float64       `arg:"--ratio-goal" help:"alert when torrents reach this ratio, instead of the seed ratio limit of the session"`
+}
+
+// ruleFile is a Prometheus rule file
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Rules returns the rule file for the configured thresholds
+func (rc *RulesCmd) Rules() ruleFile {
+	forDuration := formatDuration(rc.For)
+	warning := map[string]string{"severity": "warning"}
+	critical := map[string]string{"severity": "critical"}
+
+	ratioGoal := fmt.Sprintf("%g", rc.RatioGoal)
+	if rc.RatioGoal <= 0 {
+		ratioGoal = fmt.Sprintf(`on(instance, job) group_left() %sseed_ratio_limit{enabled="1"}`, namespace)
+	}
+
+	return ruleFile{
+		Groups: []ruleGroup{
+			{
+				Name: "transmission.rules",
+				Rules: []rule{
+					{
+						Record: "instance:" + namespace + "torrent_download_bytes:sum",
+						Expr:   fmt.Sprintf("sum by (instance, job) (%storrent_download_bytes)", namespace),
+					},
+					{
+						Record: "instance:" + namespace + "torrent_upload_bytes:sum",
+						Expr:   fmt.Sprintf("sum by (instance, job) (%storrent_upload_bytes)", namespace),
+					},
+					{
+						Record: "instance:" + namespace + "session_stats_downloaded_bytes:rate5m",
+						Expr:   fmt.Sprintf(`rate(%ssession_stats_downloaded_bytes{type="cumulative"}[5m])`, namespace),
+					},
+					{
+						Record: "instance:" + namespace + "session_stats_uploaded_bytes:rate5m",
+						Expr:   fmt.Sprintf(`rate(%ssession_stats_uploaded_bytes{type="cumulative"}[5m])`, namespace),
+					},
+				},
+			},
+			{
+				Name: "transmission.alerts",
+				Rules: []rule{
+					{
+						Alert:  "TransmissionExporterDown",
+						Expr:   fmt.Sprintf(`up{job=%q} == 0`, rc.Job),
+						For:    forDuration,
+						Labels: critical,
+						Annotations: map[string]string{
+							"summary": "Transmission exporter {{ $labels.instance }} is down.",
+						},
+					},
+					{
+						Alert:  "TransmissionUnreachable",
+						Expr:   fmt.Sprintf(`%sup{job=%q} == 0`, namespace, rc.Job),
+						For:    forDuration,
+						Labels: critical,
+						Annotations: map[string]string{
+							"summary": "Transmission can't be reached by exporter {{ $labels.instance }}.",
+						},
+					},
+					{
+						Alert:  "TransmissionFreeSpaceLow",
+						Expr:   fmt.Sprintf(`%sfree_space{job=%q} < %d`, namespace, rc.Job, rc.FreeSpaceMin),
+						For:    forDuration,
+						Labels: warning,
+						Annotations: map[string]string{
+							"summary": "Only {{ $value | humanize1024 }}B are left in {{ $labels.download_dir }} on {{ $labels.instance }}.",
+						},
+					},
+					{
+						Alert:  "TransmissionTorrentsErrored",
+						Expr:   fmt.Sprintf(`sum by (instance, job, reason) (%storrents_errored{job=%q, class="local_error"}) > %d`, namespace, rc.Job, rc.ErroredMax),
+						For:    forDuration,
+						Labels: warning,
+						Annotations: map[string]string{
+							"summary": "{{ $value }} torrents on {{ $labels.instance }} have a local error ({{ $labels.reason }}).",
+						},
+					},
+					{
+						Alert:  "TransmissionTrackerFailures",
+						Expr:   fmt.Sprintf(`sum by (instance, job, reason) (%storrents_errored{job=%q, class="tracker_error"}) > %d`, namespace, rc.Job, rc.TrackerErrMax),
+						For:    forDuration,
+						Labels: warning,
+						Annotations: map[string]string{
+							"summary": "{{ $value }} torrents on {{ $labels.instance }} have a tracker error ({{ $labels.reason }}).",
+						},
+					},
+					{
+						Alert:  "TransmissionRatioGoalReached",
+						Expr:   fmt.Sprintf(`%storrent_ratio{job=%q} >= %s`, namespace, rc.Job, ratioGoal),
+						Labels: map[string]string{"severity": "info"},
+						Annotations: map[string]string{
+							"summary": "A torrent on {{ $labels.instance }} reached a ratio of {{ $value }}.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Run writes the rule file as YAML
+func (rc *RulesCmd) Run(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(rc.Rules()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// formatDuration formats a duration the way Prometheus expects it, e.g. "5m" instead of "5m0s"
+func formatDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return ""
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alexflint/go-arg"
+	"github.com/prometheus/prometheus/model/rulefmt"
+)
+
+// runRules parses the command line like main does and returns the output of the rules subcommand
+func runRules(t *testing.T, args ...string) string {
+	t.Helper()
+	var conf Config
+	p, err := arg.NewParser(arg.Config{}, &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Rules == nil {
+		t.Fatal("rules subcommand not parsed")
+	}
+
+	if err := SetNamespace(conf.Namespace); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetNamespace("transmission") })
+
+	var out bytes.Buffer
+	if err := conf.Rules.Run(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// parseRules checks the rule file with Prometheus's own parser, which also parses the expressions
+func parseRules(t *testing.T, content string) *rulefmt.RuleGroups {
+	t.Helper()
+	groups, errs := rulefmt.Parse([]byte(content))
+	for _, err := range errs {
+		t.Errorf("invalid rules: %v", err)
+	}
+	if t.Failed() {
+		t.Fatalf("rules:\n%s", content)
+	}
+	return groups
+}
+
+// findRule returns the expression of the named recording or alerting rule
+func findRule(t *testing.T, groups *rulefmt.RuleGroups, name string) rulefmt.RuleNode {
+	t.Helper()
+	for _, g := range groups.Groups {
+		for _, r := range g.Rules {
+			if r.Record.Value == name || r.Alert.Value == name {
+				return r
+			}
+		}
+	}
+	t.Fatalf("rule %s not found", name)
+	return rulefmt.RuleNode{}
+}
+
+func TestRulesDefault(t *testing.T) {
+	groups := parseRules(t, runRules(t, "rules"))
+
+	if len(groups.Groups) != 2 {
+		t.Errorf("got %d groups, want 2", len(groups.Groups))
+	}
+	r := findRule(t, groups, "TransmissionExporterDown")
+	if want := `up{job="transmission"} == 0`; r.Expr.Value != want {
+		t.Errorf("got expression %s, want %s", r.Expr.Value, want)
+	}
+	if r.For.String() != "5m" {
+		t.Errorf("got for %s, want 5m", r.For)
+	}
+	// Without --ratio-goal, the seed ratio limit of the session is the goal.
+	r = findRule(t, groups, "TransmissionRatioGoalReached")
+	if !strings.Contains(r.Expr.Value, "transmission_seed_ratio_limit") {
+		t.Errorf("ratio goal expression %s doesn't use the seed ratio limit", r.Expr.Value)
+	}
+	findRule(t, groups, "instance:transmission_torrent_upload_bytes:sum")
+}
+
+func TestRulesNamespaceAndRatioGoal(t *testing.T) {
+	content := runRules(t, "--namespace=seedbox", "rules", "--job=seedboxes", "--for=90s", "--ratio-goal=2.5", "--free-space-min=1024")
+	groups := parseRules(t, content)
+
+	if strings.Contains(content, "transmission_") {
+		t.Errorf("rules still use the default namespace:\n%s", content)
+	}
+	findRule(t, groups, "instance:seedbox_torrent_download_bytes:sum")
+	r := findRule(t, groups, "TransmissionRatioGoalReached")
+	if !strings.Contains(r.Expr.Value, "seedbox_torrent_ratio") || !strings.Contains(r.Expr.Value, "2.5") {
+		t.Errorf("got ratio goal expression %s", r.Expr.Value)
+	}
+	if strings.Contains(r.Expr.Value, "seed_ratio_limit") {
+		t.Errorf("ratio goal expression %s uses the seed ratio limit despite --ratio-goal", r.Expr.Value)
+	}
+	r = findRule(t, groups, "TransmissionExporterDown")
+	if want := `up{job="seedboxes"} == 0`; r.Expr.Value != want {
+		t.Errorf("got expression %s, want %s", r.Expr.Value, want)
+	}
+	if r.For.String() != "1m30s" {
+		t.Errorf("got for %s, want 1m30s", r.For)
+	}
+	r = findRule(t, groups, "TransmissionFreeSpaceLow")
+	if !strings.Contains(r.Expr.Value, "1024") {
+		t.Errorf("got free space expression %s", r.Expr.Value)
+	}
+}
@@ -8,103 +8,104 @@ import (
 	"go.uber.org/zap"
 )
 
+var (
+	sessionStatsDownloadSpeed = registerMetric(&Metric{
+		Name:      "session_stats_download_speed_bytes",
+		Help:      "Current download speed in bytes",
+		Type:      prometheus.GaugeValue,
+		Collector: "session_stats",
+		Unit:      "Bps",
+	})
+	sessionStatsUploadSpeed = registerMetric(&Metric{
+		Name:      "session_stats_upload_speed_bytes",
+		Help:      "Current upload speed in bytes",
+		Type:      prometheus.GaugeValue,
+		Collector: "session_stats",
+		Unit:      "Bps",
+	})
+	sessionStatsTorrentsTotal = registerMetric(&Metric{
+		Name:      "session_stats_torrents_total",
+		Help:      "The total number of torrents",
+		Type:      prometheus.GaugeValue,
+		Collector: "session_stats",
+	})
+	sessionStatsTorrentsActive = registerMetric(&Metric{
+		Name:      "session_stats_torrents_active",
+		Help:      "The number of active torrents",
+		Type:      prometheus.GaugeValue,
+		Collector: "session_stats",
+	})
+	sessionStatsTorrentsPaused = registerMetric(&Metric{
+		Name:      "session_stats_torrents_paused",
+		Help:      "The number of paused torrents",
+		Type:      prometheus.GaugeValue,
+		Collector: "session_stats",
+	})
+	sessionStatsDownloaded = registerMetric(&Metric{
+		Name:       "session_stats_downloaded_bytes",
+		Help:       "The number of downloaded bytes",
+		Type:       prometheus.GaugeValue,
+		Labels:     []string{"type"},
+		Collector:  "session_stats",
+		Cumulative: true,
+		Unit:       "bytes",
+	})
+	sessionStatsUploaded = registerMetric(&Metric{
+		Name:       "session_stats_uploaded_bytes",
+		Help:       "The number of uploaded bytes",
+		Type:       prometheus.GaugeValue,
+		Labels:     []string{"type"},
+		Collector:  "session_stats",
+		Cumulative: true,
+		Unit:       "bytes",
+	})
+	sessionStatsFilesAdded = registerMetric(&Metric{
+		Name:       "session_stats_files_added",
+		Help:       "The number of files added",
+		Type:       prometheus.GaugeValue,
+		Labels:     []string{"type"},
+		Collector:  "session_stats",
+		Cumulative: true,
+	})
+	sessionStatsActiveTime = registerMetric(&Metric{
+		Name:      "session_stats_active",
+		Help:      "The time transmission is active since",
+		Type:      prometheus.GaugeValue,
+		Labels:    []string{"type"},
+		Collector: "session_stats",
+		Unit:      "dateTimeFromNow",
+	})
+	sessionStatsSessionCount = registerMetric(&Metric{
+		Name:       "session_stats_sessions",
+		Help:       "Count of the times transmission started",
+		Type:       prometheus.GaugeValue,
+		Labels:     []string{"type"},
+		Collector:  "session_stats",
+		Cumulative: true,
+	})
+)
+
 // SessionStatsCollector exposes SessionStats as metrics
 type SessionStatsCollector struct {
 	logger *zap.Logger
 	client *transmission.Client
 
-	DownloadSpeed  *prometheus.Desc
-	UploadSpeed    *prometheus.Desc
-	TorrentsTotal  *prometheus.Desc
-	TorrentsActive *prometheus.Desc
-	TorrentsPaused *prometheus.Desc
-
-	Downloaded   *prometheus.Desc
-	Uploaded     *prometheus.Desc
-	FilesAdded   *prometheus.Desc
-	ActiveTime   *prometheus.Desc
-	SessionCount *prometheus.Desc
+	descs metricDescs
 }
 
 // NewSessionStatsCollector takes a transmission.Client and returns a SessionStatsCollector
 func NewSessionStatsCollector(logger *zap.Logger, client *transmission.Client) *SessionStatsCollector {
-	const collectorNamespace = "session_stats_"
-
 	return &SessionStatsCollector{
 		logger: logger,
 		client: client,
 
-		DownloadSpeed: prometheus.NewDesc(
-			namespace+collectorNamespace+"download_speed_bytes",
-			"Current download speed in bytes",
-			nil,
-			nil,
-		),
-		UploadSpeed: prometheus.NewDesc(
-			namespace+collectorNamespace+"upload_speed_bytes",
-			"Current download speed in bytes",
-			nil,
-			nil,
-		),
-		TorrentsTotal: prometheus.NewDesc(
-			namespace+collectorNamespace+"torrents_total",
-			"The total number of torrents",
-			nil,
-			nil,
-		),
-		TorrentsActive: prometheus.NewDesc(
-			namespace+collectorNamespace+"torrents_active",
-			"The number of active torrents",
-			nil,
-			nil,
-		),
-		TorrentsPaused: prometheus.NewDesc(
-			namespace+collectorNamespace+"torrents_paused",
-			"The number of paused torrents",
-			nil,
-			nil,
-		),
-
-		Downloaded: prometheus.NewDesc(
-			namespace+collectorNamespace+"downloaded_bytes",
-			"The number of downloaded bytes",
-			[]string{"type"},
-			nil,
-		),
-		Uploaded: prometheus.NewDesc(
-			namespace+collectorNamespace+"uploaded_bytes",
-			"The number of uploaded bytes",
-			[]string{"type"},
-			nil,
-		),
-		FilesAdded: prometheus.NewDesc(
-			namespace+collectorNamespace+"files_added",
-			"The number of files added",
-			[]string{"type"},
-			nil,
-		),
-		ActiveTime: prometheus.NewDesc(
-			namespace+collectorNamespace+"active",
-			"The time transmission is active since",
-			[]string{"type"},
-			nil,
-		),
-		SessionCount: prometheus.NewDesc(
-			namespace+collectorNamespace+"sessions",
-			"Count of the times transmission started",
-			[]string{"type"},
-			nil,
-		),
+		descs: newMetricDescs("session_stats", nil),
 	}
 }
 
 // Describe implements the prometheus.Collector interface
 func (sc *SessionStatsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- sc.DownloadSpeed
-	ch <- sc.UploadSpeed
-	ch <- sc.TorrentsTotal
-	ch <- sc.TorrentsActive
-	ch <- sc.TorrentsPaused
+	sc.descs.Describe(ch)
 }
 
 // Collect implements the prometheus.Collector interface
@@ -115,31 +116,11 @@ func (sc *SessionStatsCollector) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
-	ch <- prometheus.MustNewConstMetric(
-		sc.DownloadSpeed,
-		prometheus.GaugeValue,
-		float64(stats.DownloadSpeed),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.UploadSpeed,
-		prometheus.GaugeValue,
-		float64(stats.UploadSpeed),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.TorrentsTotal,
-		prometheus.GaugeValue,
-		float64(stats.TorrentCount),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.TorrentsActive,
-		prometheus.GaugeValue,
-		float64(stats.ActiveTorrentCount),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.TorrentsPaused,
-		prometheus.GaugeValue,
-		float64(stats.PausedTorrentCount),
-	)
+	sc.descs.Collect(ch, sessionStatsDownloadSpeed, float64(stats.DownloadSpeed))
+	sc.descs.Collect(ch, sessionStatsUploadSpeed, float64(stats.UploadSpeed))
+	sc.descs.Collect(ch, sessionStatsTorrentsTotal, float64(stats.TorrentCount))
+	sc.descs.Collect(ch, sessionStatsTorrentsActive, float64(stats.ActiveTorrentCount))
+	sc.descs.Collect(ch, sessionStatsTorrentsPaused, float64(stats.PausedTorrentCount))
 
 	types := []string{"current", "cumulative"}
 	for _, t := range types {
@@ -150,39 +131,14 @@ func (sc *SessionStatsCollector) Collect(ch chan<- prometheus.Metric) {
 			stateStats = stats.CumulativeStats
 		}
 
-		ch <- prometheus.MustNewConstMetric(
-			sc.Downloaded,
-			prometheus.GaugeValue,
-			float64(stateStats.DownloadedBytes),
-			t,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			sc.Uploaded,
-			prometheus.GaugeValue,
-			float64(stateStats.UploadedBytes),
-			t,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			sc.FilesAdded,
-			prometheus.GaugeValue,
-			float64(stateStats.FilesAdded),
-			t,
-		)
+		sc.descs.Collect(ch, sessionStatsDownloaded, float64(stateStats.DownloadedBytes), t)
+		sc.descs.Collect(ch, sessionStatsUploaded, float64(stateStats.UploadedBytes), t)
+		sc.descs.Collect(ch, sessionStatsFilesAdded, float64(stateStats.FilesAdded), t)
 
 		dur := time.Duration(stateStats.SecondsActive) * time.Second
 		timestamp := time.Now().Add(-1 * dur).Unix()
 
-		ch <- prometheus.MustNewConstMetric(
-			sc.ActiveTime,
-			prometheus.GaugeValue,
-			float64(timestamp),
-			t,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			sc.SessionCount,
-			prometheus.GaugeValue,
-			float64(stateStats.SessionCount),
-			t,
-		)
+		sc.descs.Collect(ch, sessionStatsActiveTime, float64(timestamp), t)
+		sc.descs.Collect(ch, sessionStatsSessionCount, float64(stateStats.SessionCount), t)
 	}
 }
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// SinkConfig holds the configuration of the InfluxDB and StatsD sinks
+type SinkConfig struct {
+	SinkInterval        time.Duration `arg:"--sink-interval,env:SINK_INTERVAL" default:"30s" help:"interval at which metrics are written to the InfluxDB and StatsD sinks"`
+	InfluxDBURL         string        `arg:"--influxdb-url,env:INFLUXDB_URL" help:"InfluxDB to write metrics to, e.g. http://localhost:8086, credentials of the v1 API may be given in the URL"`
+	InfluxDBAPI         string        `arg:"--influxdb-api,env:INFLUXDB_API" default:"v2" help:"InfluxDB write API: v1 or v2"`
+	InfluxDBDatabase    string        `arg:"--influxdb-database,env:INFLUXDB_DATABASE" help:"database to write to with the v1 API"`
+	InfluxDBRetention   string        `arg:"--influxdb-retention-policy,env:INFLUXDB_RETENTION_POLICY" help:"retention policy to write to with the v1 API [default: the database's default]"`
+	InfluxDBOrg         string        `arg:"--influxdb-org,env:INFLUXDB_ORG" help:"organization to write to with the v2 API"`
+	InfluxDBBucket      string        `arg:"--influxdb-bucket,env:INFLUXDB_BUCKET" help:"bucket to write to with the v2 API"`
+	InfluxDBToken       string        `arg:"--influxdb-token,env:INFLUXDB_TOKEN" help:"API token of the v2 API"`
+	InfluxDBTimeout     time.Duration `arg:"--influxdb-timeout,env:INFLUXDB_TIMEOUT" default:"10s" help:"timeout of a write to InfluxDB"`
+	InfluxDBStdout      bool          `arg:"--influxdb-stdout,env:INFLUXDB_STDOUT" help:"write metrics to stdout in InfluxDB line protocol, e.g. for Telegraf's execd input"`
+	StatsDAddress       string        `arg:"--statsd-address,env:STATSD_ADDRESS" help:"StatsD server to send metrics to over UDP, e.g. localhost:8125"`
+	StatsDTags          string        `arg:"--statsd-tags,env:STATSD_TAGS" default:"dogstatsd" help:"format of the labels sent to StatsD: dogstatsd, influxdb (as read by Telegraf) or none"`
+	StatsDMaxPacketSize int           `arg:"--statsd-max-packet-size,env:STATSD_MAX_PACKET_SIZE" default:"1432" help:"maximum size of the UDP packets sent to StatsD"`
+}
+
+// Sample is a single value of a gathered metric. Histograms and summaries are split into their
+// series the way Prometheus stores them.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	// Counter marks cumulative values, including the sums, counts and buckets of histograms and
+	// summaries
+	Counter bool
+	// Metric is the registered metric of the sample, or nil for other metrics like the Go metrics
+	Metric *Metric
+}
+
+// Samples flattens the gathered metric families into samples
+func Samples(families []*dto.MetricFamily) []Sample {
+	var samples []Sample
+	for _, f := range families {
+		name := f.GetName()
+		metric := registeredMetric(name)
+		for _, m := range f.Metric {
+			add := func(suffix string, value float64, counter bool, extra ...string) {
+				labels := make(map[string]string, len(m.Label)+1)
+				for _, l := range m.Label {
+					labels[l.GetName()] = l.GetValue()
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels[extra[i]] = extra[i+1]
+				}
+				samples = append(samples, Sample{
+					Name:    name + suffix,
+					Labels:  labels,
+					Value:   value,
+					Counter: counter,
+					Metric:  metric,
+				})
+			}
+
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue(), true)
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue(), false)
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue(), false)
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, bucket := range h.Bucket {
+					infSeen = infSeen || math.IsInf(bucket.GetUpperBound(), 1)
+					add("_bucket", float64(bucket.GetCumulativeCount()), true, "le", formatFloat(bucket.GetUpperBound()))
+				}
+				if !infSeen {
+					add("_bucket", float64(h.GetSampleCount()), true, "le", "+Inf")
+				}
+				add("_sum", h.GetSampleSum(), true)
+				add("_count", float64(h.GetSampleCount()), true)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add("", q.GetValue(), false, "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum(), true)
+				add("_count", float64(s.GetSampleCount()), true)
+			}
+		}
+	}
+	return samples
+}
+
+// formatFloat formats a float the way Prometheus formats le and quantile label values
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// Sink writes the gathered samples to an output other than the metrics endpoint
+type Sink interface {
+	Write(samples []Sample, now time.Time) error
+}
+
+// Sinks periodically gathers the metrics of a registry and writes them to every configured sink
+type Sinks struct {
+	logger   *zap.Logger
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	sinks    map[string]Sink
+}
+
+// NewSinks creates the sinks enabled in the configuration, returning nil if there are none
+func NewSinks(logger *zap.Logger, gatherer prometheus.Gatherer, conf SinkConfig) (*Sinks, error) {
+	sinks := make(map[string]Sink)
+	if conf.InfluxDBURL != "" {
+		sink, err := NewInfluxDBSink(conf)
+		if err != nil {
+			return nil, err
+		}
+		sinks["influxdb"] = sink
+	}
+	if conf.InfluxDBStdout {
+		sinks["stdout"] = &InfluxDBSink{write: func(body []byte) error {
+			_, err := os.Stdout.Write(body)
+			return err
+		}}
+	}
+	if conf.StatsDAddress != "" {
+		sink, err := NewStatsDSink(conf)
+		if err != nil {
+			return nil, err
+		}
+		sinks["statsd"] = sink
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	return &Sinks{
+		logger:   logger,
+		gatherer: gatherer,
+		interval: conf.SinkInterval,
+		sinks:    sinks,
+	}, nil
+}
+
+// Run writes the metrics at the configured interval, forever
+func (s *Sinks) Run() {
+	for range time.Tick(s.interval) {
+		families, err := s.gatherer.Gather()
+		if err != nil {
+			// Gathering errors are partial, so the metrics that could be gathered are still written.
+			s.logger.Warn("Failed to gather some metrics.", zap.Error(err))
+		}
+
+		samples, now := Samples(families), time.Now()
+		for name, sink := range s.sinks {
+			if err := sink.Write(samples, now); err != nil {
+				s.logger.Error("Failed to write metrics to sink.", zap.String("sink", name), zap.Error(err))
+			}
+		}
+	}
+}
+
+// InfluxDBSink writes samples in InfluxDB line protocol. Registered metrics become fields of a
+// measurement per collector, e.g. transmission_torrent,id=1,name=foo ratio=1.5, and other metrics
+// a measurement of their own with a single value field.
+type InfluxDBSink struct {
+	write func(body []byte) error
+}
+
+// NewInfluxDBSink creates an InfluxDBSink writing to the v1 or v2 write API of an InfluxDB
+func NewInfluxDBSink(conf SinkConfig) (*InfluxDBSink, error) {
+	u, err := neturl.ParseRequestURI(conf.InfluxDBURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q", conf.InfluxDBURL)
+	}
+
+	query := neturl.Values{"precision": {"ns"}}
+	headers := map[string]string{"Content-Type": "text/plain; charset=utf-8"}
+	switch conf.InfluxDBAPI {
+	case "v1":
+		if conf.InfluxDBDatabase == "" {
+			return nil, fmt.Errorf("the InfluxDB v1 API needs a database")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+		query.Set("db", conf.InfluxDBDatabase)
+		if conf.InfluxDBRetention != "" {
+			query.Set("rp", conf.InfluxDBRetention)
+		}
+		// Credentials in the URL are passed as the u and p query parameters of the v1 API.
+		if u.User != nil {
+			query.Set("u", u.User.Username())
+			if password, ok := u.User.Password(); ok {
+				query.Set("p", password)
+			}
+			u.User = nil
+		}
+	case "v2":
+		if conf.InfluxDBOrg == "" || conf.InfluxDBBucket == "" {
+			return nil, fmt.Errorf("the InfluxDB v2 API needs an organization and a bucket")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		query.Set("org", conf.InfluxDBOrg)
+		query.Set("bucket", conf.InfluxDBBucket)
+		if conf.InfluxDBToken != "" {
+			headers["Authorization"] = "Token " + conf.InfluxDBToken
+		}
+	default:
+		return nil, fmt.Errorf("unknown InfluxDB API %q", conf.InfluxDBAPI)
+	}
+	u.RawQuery = query.Encode()
+	url := u.String()
+
+	client := &headerClient{
+		Client:  http.Client{Timeout: conf.InfluxDBTimeout},
+		headers: headers,
+	}
+	return &InfluxDBSink{write: func(body []byte) error {
+		req, err := http.NewRequestWithContext(context.Background(), "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %s: %s", res.Status, bytes.TrimSpace(msg))
+		}
+		return nil
+	}}, nil
+}
+
+// influxPoint is a line of line protocol, before its fields are formatted
+type influxPoint struct {
+	measurement string
+	tags        string
+	fields      map[string]float64
+}
+
+// Write implements the Sink interface
+func (s *InfluxDBSink) Write(samples []Sample, now time.Time) error {
+	return s.write(influxLines(samples, now))
+}
+
+// influxLines formats the samples as line protocol, with the samples sharing a measurement and
+// tags written as fields of a single line
+func influxLines(samples []Sample, now time.Time) []byte {
+	var points []*influxPoint
+	byKey := make(map[string]*influxPoint)
+	for _, s := range samples {
+		// Line protocol has no representation of NaN and infinities.
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+
+		measurement, field := s.Name, "value"
+		if s.Metric != nil {
+			measurement = namespace + s.Metric.Collector
+			field = strings.TrimPrefix(strings.TrimPrefix(s.Name, namespace), s.Metric.Collector+"_")
+		}
+
+		// Empty tag values aren't allowed, so those tags are left out.
+		names := make([]string, 0, len(s.Labels))
+		for name, value := range s.Labels {
+			if value != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var tags strings.Builder
+		for _, name := range names {
+			tags.WriteByte(',')
+			tags.WriteString(influxEscaper.Replace(name))
+			tags.WriteByte('=')
+			tags.WriteString(influxEscaper.Replace(s.Labels[name]))
+		}
+
+		key := measurement + tags.String()
+		p, ok := byKey[key]
+		if !ok {
+			p = &influxPoint{
+				measurement: influxMeasurementEscaper.Replace(measurement),
+				tags:        tags.String(),
+				fields:      make(map[string]float64),
+			}
+			byKey[key] = p
+			points = append(points, p)
+		}
+		p.fields[field] = s.Value
+	}
+
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	var b bytes.Buffer
+	for _, p := range points {
+		fields := make([]string, 0, len(p.fields))
+		for field := range p.fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		b.WriteString(p.measurement)
+		b.WriteString(p.tags)
+		for i, field := range fields {
+			if i == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(influxEscaper.Replace(field))
+			b.WriteByte('=')
+			b.WriteString(strconv.FormatFloat(p.fields[field], 'g', -1, 64))
+		}
+		b.WriteByte(' ')
+		b.WriteString(ts)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+var (
+	// influxEscaper escapes tag keys, tag values and field keys
+	influxEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	// influxMeasurementEscaper escapes measurements
+	influxMeasurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `, "\n", `\n`)
+)
+
+// StatsDSink sends samples to a StatsD or DogStatsD server over UDP. Counters are sent as the
+// increase since the previous write, everything else as gauges.
+type StatsDSink struct {
+	conn          net.Conn
+	tags          string
+	maxPacketSize int
+
+	// counters are the last values of the counters, by series
+	counters map[string]float64
+}
+
+// NewStatsDSink creates a StatsDSink sending to the configured address
+func NewStatsDSink(conf SinkConfig) (*StatsDSink, error) {
+	switch conf.StatsDTags {
+	case "dogstatsd", "influxdb", "none":
+	default:
+		return nil, fmt.Errorf("unknown StatsD tag format %q", conf.StatsDTags)
+	}
+	if conf.StatsDMaxPacketSize <= 0 {
+		return nil, fmt.Errorf("invalid StatsD packet size %d", conf.StatsDMaxPacketSize)
+	}
+
+	conn, err := net.Dial("udp", conf.StatsDAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid StatsD address: %w", err)
+	}
+	return &StatsDSink{
+		conn:          conn,
+		tags:          conf.StatsDTags,
+		maxPacketSize: conf.StatsDMaxPacketSize,
+		counters:      make(map[string]float64),
+	}, nil
+}
+
+// Write implements the Sink interface
+func (s *StatsDSink) Write(samples []Sample, _ time.Time) error {
+	var packet []byte
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+
+	for _, sample := range samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+
+		for _, line := range s.lines(sample) {
+			if len(packet) > 0 && len(packet)+1+len(line) > s.maxPacketSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	return flush()
+}
+
+// lines returns the StatsD lines of a sample, leaving out empty labels like Prometheus does
+func (s *StatsDSink) lines(sample Sample) []string {
+	names := make([]string, 0, len(sample.Labels))
+	for name, value := range sample.Labels {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	name, suffix := statsdReplacer.Replace(sample.Name), ""
+	switch s.tags {
+	case "dogstatsd":
+		tags := make([]string, 0, len(names))
+		for _, n := range names {
+			tags = append(tags, statsdReplacer.Replace(n)+":"+statsdReplacer.Replace(sample.Labels[n]))
+		}
+		if len(tags) > 0 {
+			suffix = "|#" + strings.Join(tags, ",")
+		}
+	case "influxdb":
+		for _, n := range names {
+			name += "," + statsdReplacer.Replace(n) + "=" + statsdReplacer.Replace(sample.Labels[n])
+		}
+	}
+
+	value := sample.Value
+	if sample.Counter {
+		key := sample.Name
+		for _, n := range names {
+			key += "\xff" + n + "\xff" + sample.Labels[n]
+		}
+		previous, seen := s.counters[key]
+		s.counters[key] = value
+		// The first value only serves as a baseline, and a counter that went down was reset.
+		if !seen {
+			return nil
+		}
+		if value >= previous {
+			value -= previous
+		}
+		return []string{name + ":" + formatStatsDValue(value) + "|c" + suffix}
+	}
+
+	// A signed gauge value changes the gauge instead of setting it, so negative values are set by
+	// resetting the gauge first.
+	if value < 0 {
+		return []string{
+			name + ":0|g" + suffix,
+			name + ":" + formatStatsDValue(value) + "|g" + suffix,
+		}
+	}
+	return []string{name + ":" + formatStatsDValue(value) + "|g" + suffix}
+}
+
+// statsdReplacer replaces the characters separating names, values, types and tags
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", ",", "_", "=", "_", "#", "_", "@", "_", " ", "_", "\n", "_")
+
+func formatStatsDValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxLines(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ratio, upload := lookupMetric("torrent_ratio"), lookupMetric("torrent_upload_bytes")
+
+	for _, test := range []struct {
+		name    string
+		samples []Sample
+		want    string
+	}{
+		{
+			name: "escaping",
+			samples: []Sample{{
+				Name:   "odd name,with=chars",
+				Labels: map[string]string{"tag key": `a,b=c d\`},
+				Value:  1,
+			}},
+			want: `odd\ name\,with=chars,tag\ key=a\,b\=c\ d\\ value=1 1700000000000000000` + "\n",
+		},
+		{
+			name: "escaped field names",
+			samples: []Sample{{
+				Name:   namespace + "torrent_odd field,with=chars",
+				Labels: map[string]string{"id": "1"},
+				Value:  2,
+				Metric: &Metric{Collector: "torrent"},
+			}},
+			want: `transmission_torrent,id=1 odd\ field\,with\=chars=2 1700000000000000000` + "\n",
+		},
+		{
+			name: "empty tags",
+			samples: []Sample{{
+				Name:   "go_info",
+				Labels: map[string]string{"version": "go1.21", "empty": ""},
+				Value:  1,
+			}},
+			want: "go_info,version=go1.21 value=1 1700000000000000000\n",
+		},
+		{
+			name: "merged fields",
+			samples: []Sample{
+				{Name: upload.FQName(), Labels: map[string]string{"id": "1"}, Value: 1024, Metric: upload},
+				{Name: ratio.FQName(), Labels: map[string]string{"id": "2"}, Value: 0.25, Metric: ratio},
+				{Name: ratio.FQName(), Labels: map[string]string{"id": "1"}, Value: 1.5, Metric: ratio},
+			},
+			want: "transmission_torrent,id=1 ratio=1.5,upload_bytes=1024 1700000000000000000\n" +
+				"transmission_torrent,id=2 ratio=0.25 1700000000000000000\n",
+		},
+		{
+			name: "non-finite values",
+			samples: []Sample{
+				{Name: "nan", Value: math.NaN()},
+				{Name: "inf", Value: math.Inf(1)},
+				{Name: "finite", Value: -1},
+			},
+			want: "finite value=-1 1700000000000000000\n",
+		},
+	} {
+		if got := string(influxLines(test.samples, now)); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInfluxDBSinkURL(t *testing.T) {
+	var request *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		request, body = r, string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	withCredentials := strings.Replace(server.URL, "http://", "http://user:secret@", 1)
+
+	for _, test := range []struct {
+		conf   SinkConfig
+		path   string
+		query  string
+		header string
+	}{
+		{
+			conf:  SinkConfig{InfluxDBURL: server.URL, InfluxDBAPI: "v1", InfluxDBDatabase: "transmission"},
+			path:  "/write",
+			query: "db=transmission&precision=ns",
+		},
+		{
+			conf:  SinkConfig{InfluxDBURL: withCredentials + "/influx/", InfluxDBAPI: "v1", InfluxDBDatabase: "transmission", InfluxDBRetention: "week"},
+			path:  "/influx/write",
+			query: "db=transmission&p=secret&precision=ns&rp=week&u=user",
+		},
+		{
+			conf:   SinkConfig{InfluxDBURL: server.URL, InfluxDBAPI: "v2", InfluxDBOrg: "home", InfluxDBBucket: "transmission", InfluxDBToken: "token"},
+			path:   "/api/v2/write",
+			query:  "bucket=transmission&org=home&precision=ns",
+			header: "Token token",
+		},
+	} {
+		sink, err := NewInfluxDBSink(test.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write([]Sample{{Name: "up", Value: 1}}, time.Unix(1, 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if request.URL.Path != test.path || request.URL.RawQuery != test.query {
+			t.Errorf("%s: got %s?%s, want %s?%s", test.conf.InfluxDBURL, request.URL.Path, request.URL.RawQuery, test.path, test.query)
+		}
+		if got := request.Header.Get("Authorization"); got != test.header {
+			t.Errorf("%s: got authorization %q, want %q", test.conf.InfluxDBURL, got, test.header)
+		}
+		if body != "up value=1 1000000000\n" {
+			t.Errorf("%s: got body %q", test.conf.InfluxDBURL, body)
+		}
+	}
+
+	for _, conf := range []SinkConfig{
+		{InfluxDBURL: "localhost:8086", InfluxDBAPI: "v2", InfluxDBOrg: "o", InfluxDBBucket: "b"},
+		{InfluxDBURL: server.URL, InfluxDBAPI: "v1"},
+		{InfluxDBURL: server.URL, InfluxDBAPI: "v2", InfluxDBOrg: "o"},
+		{InfluxDBURL: server.URL, InfluxDBAPI: "v3"},
+	} {
+		if _, err := NewInfluxDBSink(conf); err == nil {
+			t.Errorf("%+v: got no error", conf)
+		}
+	}
+}
+
+// statsdListener returns a local UDP listener for a StatsDSink
+func statsdListener(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readPackets returns the packets received until none arrive for a while
+func readPackets(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+	var packets []string
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func newTestStatsDSink(t *testing.T, conn *net.UDPConn, tags string, maxPacketSize int) *StatsDSink {
+	sink, err := NewStatsDSink(SinkConfig{
+		StatsDAddress:       conn.LocalAddr().String(),
+		StatsDTags:          tags,
+		StatsDMaxPacketSize: maxPacketSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func TestStatsDSink(t *testing.T) {
+	labels := map[string]string{"b": "2", "a": "x:y|z", "empty": ""}
+	for _, test := range []struct {
+		tags   string
+		sample Sample
+		want   string
+	}{
+		{"dogstatsd", Sample{Name: "ratio", Labels: labels, Value: 1.5}, "ratio:1.5|g|#a:x_y_z,b:2"},
+		{"influxdb", Sample{Name: "ratio", Labels: labels, Value: 1.5}, "ratio,a=x_y_z,b=2:1.5|g"},
+		{"none", Sample{Name: "ratio", Labels: labels, Value: 1.5}, "ratio:1.5|g"},
+		{"none", Sample{Name: "odd:name", Value: 1e21}, "odd_name:1000000000000000000000|g"},
+		// A signed value would change the gauge, so it's reset to 0 first.
+		{"dogstatsd", Sample{Name: "eta", Labels: map[string]string{"id": "1"}, Value: -2}, "eta:0|g|#id:1\neta:-2|g|#id:1"},
+	} {
+		conn := statsdListener(t)
+		sink := newTestStatsDSink(t, conn, test.tags, 1432)
+		if err := sink.Write([]Sample{test.sample}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if packets := readPackets(t, conn); len(packets) != 1 || packets[0] != test.want {
+			t.Errorf("%s %v: got packets %q, want %q", test.tags, test.sample, packets, test.want)
+		}
+	}
+}
+
+func TestStatsDSinkCounters(t *testing.T) {
+	conn := statsdListener(t)
+	sink := newTestStatsDSink(t, conn, "dogstatsd", 1432)
+
+	for _, test := range []struct {
+		value float64
+		want  []string
+	}{
+		// The first value is the baseline of the deltas.
+		{10, nil},
+		{15, []string{"errors:5|c|#method:get"}},
+		{15, []string{"errors:0|c|#method:get"}},
+		// A counter that went down was reset, so all of its value is new.
+		{3, []string{"errors:3|c|#method:get"}},
+		{4, []string{"errors:1|c|#method:get"}},
+	} {
+		sample := Sample{Name: "errors", Labels: map[string]string{"method": "get"}, Value: test.value, Counter: true}
+		if err := sink.Write([]Sample{sample}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if packets := readPackets(t, conn); strings.Join(packets, "|") != strings.Join(test.want, "|") {
+			t.Errorf("value %g: got packets %q, want %q", test.value, packets, test.want)
+		}
+	}
+}
+
+func TestStatsDSinkPacketSize(t *testing.T) {
+	const maxPacketSize = 64
+	conn := statsdListener(t)
+	sink := newTestStatsDSink(t, conn, "none", maxPacketSize)
+
+	var samples []Sample
+	var want []string
+	for i := 0; i < 20; i++ {
+		s := Sample{Name: "transmission_torrent_ratio_" + string(rune('a'+i)), Value: float64(i)}
+		samples = append(samples, s)
+		want = append(want, sink.lines(s)...)
+	}
+	if err := sink.Write(samples, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := readPackets(t, conn)
+	if len(packets) < 2 {
+		t.Errorf("got %d packets, want the lines split", len(packets))
+	}
+	var lines []string
+	for _, p := range packets {
+		if len(p) > maxPacketSize {
+			t.Errorf("packet of %d bytes is over %d bytes", len(p), maxPacketSize)
+		}
+		lines = append(lines, strings.Split(p, "\n")...)
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got lines %q, want %q", lines, want)
+	}
+}
+
+func TestStatsDSinkConfigErrors(t *testing.T) {
+	for _, conf := range []SinkConfig{
+		{StatsDAddress: "localhost:8125", StatsDTags: "graphite", StatsDMaxPacketSize: 1432},
+		{StatsDAddress: "localhost:8125", StatsDTags: "none", StatsDMaxPacketSize: 0},
+		{StatsDAddress: "localhost", StatsDTags: "none", StatsDMaxPacketSize: 1432},
+	} {
+		if _, err := NewStatsDSink(conf); err == nil {
+			t.Errorf("%+v: got no error", conf)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+// statusFields are the torrent-get fields shown on the status page
+var statusFields = []string{"name", "hashString", "status", "rateDownload", "rateUpload", "uploadRatio", "error", "errorString"}
+
+// StatusLink is a link shown on the status page
+type StatusLink struct {
+	Title string
+	Path  string
+}
+
+// StatusPage renders an overview of the daemon and its torrents as HTML
+type StatusPage struct {
+	logger *zap.Logger
+	client *transmission.Client
+	cache  *TorrentCache
+	top    int
+	links  []StatusLink
+	tmpl   *template.Template
+
+	lastScrape time.Time
+	lock       sync.Mutex
+}
+
+// statusData is what the status page template is rendered with
+type statusData struct {
+	Now          time.Time
+	Links        []StatusLink
+	Latency      time.Duration
+	SessionError error
+	Session      *transmission.Session
+	Stats        *transmission.SessionStats
+	LastScrape   time.Time
+	LastUpdate   time.Time
+	UpdateError  error
+	Torrents     int
+	TopDownload  []transmission.Torrent
+	TopUpload    []transmission.Torrent
+	TopRatio     []transmission.Torrent
+	Errored      []transmission.Torrent
+}
+
+// NewStatusPage creates a StatusPage showing the top torrents by rate and ratio
+func NewStatusPage(logger *zap.Logger, client *transmission.Client, cache *TorrentCache, top int, links []StatusLink) *StatusPage {
+	cache.AddFields(statusFields...)
+
+	tmpl := template.Must(template.New("status.html").Funcs(template.FuncMap{
+		"bytes":  formatBytes,
+		"status": transmission.StatusName,
+		"error":  transmission.ErrorName,
+		"int64":  func(n int) int64 { return int64(n) },
+		"ago": func(now, t time.Time) string {
+			if t.IsZero() {
+				return "never"
+			}
+			return now.Sub(t).Round(time.Second).String() + " ago"
+		},
+	}).ParseFS(templates, "templates/status.html"))
+
+	return &StatusPage{
+		logger: logger,
+		client: client,
+		cache:  cache,
+		top:    top,
+		links:  links,
+		tmpl:   tmpl,
+	}
+}
+
+// Instrument records the time of the scrapes handled by the given handler
+func (sp *StatusPage) Instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp.lock.Lock()
+		sp.lastScrape = time.Now()
+		sp.lock.Unlock()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP renders the status page
+func (sp *StatusPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := statusData{Now: time.Now(), Links: sp.links}
+	sp.lock.Lock()
+	data.LastScrape = sp.lastScrape
+	sp.lock.Unlock()
+
+	// Connectivity is judged by the session request, as the torrents may come from the cache.
+	data.Session, data.SessionError = sp.client.GetSession()
+	data.Latency = time.Since(data.Now).Round(time.Millisecond)
+	if data.SessionError == nil {
+		data.Stats, data.SessionError = sp.client.GetSessionStats()
+	}
+
+	torrents, err := sp.cache.Torrents()
+	data.LastUpdate, data.UpdateError = sp.cache.LastUpdate()
+	if err == nil {
+		data.Torrents = len(torrents)
+		data.TopDownload = sp.topTorrents(torrents, func(t *transmission.Torrent) float64 { return float64(t.RateDownload) })
+		data.TopUpload = sp.topTorrents(torrents, func(t *transmission.Torrent) float64 { return float64(t.RateUpload) })
+		data.TopRatio = sp.topTorrents(torrents, func(t *transmission.Torrent) float64 { return t.UploadRatio })
+		for _, t := range torrents {
+			if t.Error != transmission.ErrorNone {
+				data.Errored = append(data.Errored, t)
+			}
+		}
+		sort.Sort(transmission.ByName(data.Errored))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := sp.tmpl.Execute(w, data); err != nil {
+		sp.logger.Error("Failed to render status page.", zap.Error(err))
+	}
+}
+
+// topTorrents returns the torrents with the highest positive values, highest first
+func (sp *StatusPage) topTorrents(torrents []transmission.Torrent, value func(*transmission.Torrent) float64) []transmission.Torrent {
+	var top []transmission.Torrent
+	for i := range torrents {
+		if value(&torrents[i]) > 0 {
+			top = append(top, torrents[i])
+		}
+	}
+	sort.Sort(transmission.ByID(top))
+	sort.SliceStable(top, func(i, j int) bool { return value(&top[i]) > value(&top[j]) })
+	if len(top) > sp.top {
+		top = top[:sp.top]
+	}
+	return top
+}
+
+// formatBytes formats an amount of bytes with a binary unit, e.g. 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+// TextfileConfig holds the configuration of the one-shot textfile mode
+type TextfileConfig struct {
+	Once     bool   `arg:"--once,env:ONCE" help:"gather all metrics once, write them to --output and exit instead of serving them, e.g. for node_exporter's textfile collector"`
+	Output   string `arg:"--output,env:OUTPUT" help:"file the metrics are atomically written to with --once, e.g. /var/lib/node_exporter/textfile/transmission.prom [default: stdout]"`
+	LockFile string `arg:"--lock-file,env:LOCK_FILE" help:"file locked while running with --once, so that overlapping runs, e.g. from cron, exit right away"`
+}
+
+// Exit codes of the one-shot textfile mode
+const (
+	exitOK = 0
+	// exitError means the metrics weren't written
+	exitError = 1
+	// exitScrapeFailed means the metrics were written, but Transmission couldn't be fully scraped
+	exitScrapeFailed = 2
+	// exitLocked means another run holds the lock file
+	exitLocked = 3
+)
+
+// errLocked is returned when the lock file is held by another process
+var errLocked = errors.New("lock file is held by another process")
+
+// RunOnce gathers the metrics of the registry once and writes them in the text exposition format,
+// returning the exit code
+func RunOnce(logger *zap.Logger, gatherer prometheus.Gatherer, cache *TorrentCache, conf TextfileConfig) int {
+	if conf.LockFile != "" {
+		unlock, err := lockFile(conf.LockFile)
+		if errors.Is(err, errLocked) {
+			logger.Warn("Another run is in progress, exiting.", zap.String("lock_file", conf.LockFile))
+			return exitLocked
+		}
+		if err != nil {
+			logger.Error("Failed to lock the lock file.", zap.String("lock_file", conf.LockFile), zap.Error(err))
+			return exitError
+		}
+		defer unlock()
+	}
+
+	// Gathering errors are partial, so the metrics that could be gathered are still written.
+	scrapeFailed := false
+	families, err := gatherer.Gather()
+	if err != nil {
+		logger.Error("Failed to gather some metrics.", zap.Error(err))
+		scrapeFailed = true
+	}
+	if _, err := cache.LastUpdate(); err != nil {
+		scrapeFailed = true
+	}
+	for _, f := range families {
+		if f.GetName() == sessionUp.FQName() {
+			for _, m := range f.Metric {
+				if m.GetGauge().GetValue() == 0 {
+					scrapeFailed = true
+				}
+			}
+		}
+	}
+
+	if conf.Output == "" {
+		err = writeMetrics(os.Stdout, families)
+	} else {
+		err = writeMetricsFile(conf.Output, families)
+	}
+	if err != nil {
+		logger.Error("Failed to write metrics.", zap.String("output", conf.Output), zap.Error(err))
+		return exitError
+	}
+
+	if scrapeFailed {
+		return exitScrapeFailed
+	}
+	return exitOK
+}
+
+func writeMetrics(w io.Writer, families []*dto.MetricFamily) error {
+	for _, f := range families {
+		if _, err := expfmt.MetricFamilyToText(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMetricsFile writes the metrics to a temporary file next to path and renames it, so that
+// readers never see a partially written file. The temporary file doesn't end in .prom, so the
+// textfile collector ignores it.
+func writeMetricsFile(path string, families []*dto.MetricFamily) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := writeMetrics(f, families); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// testFamilies returns the families of a scrape with the given value of the up metric
+func testFamilies(up float64) []*dto.MetricFamily {
+	return []*dto.MetricFamily{{
+		Name:   proto.String(sessionUp.FQName()),
+		Help:   proto.String(sessionUp.Help),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(up)}}},
+	}}
+}
+
+// dirFiles returns the names of the files in a directory
+func dirFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRunOnce(t *testing.T) {
+	const metrics = "# HELP transmission_up Whether Transmission could be reached (1) or not (0)\n" +
+		"# TYPE transmission_up gauge\n"
+
+	for _, test := range []struct {
+		name     string
+		families []*dto.MetricFamily
+		err      error
+		cacheErr error
+		code     int
+		want     string
+	}{
+		{"scraped", testFamilies(1), nil, nil, exitOK, metrics + "transmission_up 1\n"},
+		{"down", testFamilies(0), nil, nil, exitScrapeFailed, metrics + "transmission_up 0\n"},
+		// The metrics that could be gathered are still written.
+		{"gathering failed", testFamilies(1), errors.New("collector failed"), nil, exitScrapeFailed, metrics + "transmission_up 1\n"},
+		{"torrents failed", testFamilies(1), nil, errors.New("torrent-get failed"), exitScrapeFailed, metrics + "transmission_up 1\n"},
+	} {
+		dir := t.TempDir()
+		output := filepath.Join(dir, "transmission.prom")
+		cache := newTestCache(t)
+		cache.err = test.cacheErr
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return test.families, test.err
+		})
+
+		if code := RunOnce(zap.NewNop(), gatherer, cache, TextfileConfig{Once: true, Output: output}); code != test.code {
+			t.Errorf("%s: got exit code %d, want %d", test.name, code, test.code)
+		}
+		b, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(b) != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, b, test.want)
+		}
+		if files := dirFiles(t, dir); len(files) != 1 {
+			t.Errorf("%s: got files %v, want only the output", test.name, files)
+		}
+	}
+}
+
+func TestRunOnceErrors(t *testing.T) {
+	dir := t.TempDir()
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return testFamilies(1), nil })
+
+	// The output dir doesn't exist.
+	conf := TextfileConfig{Once: true, Output: filepath.Join(dir, "missing", "transmission.prom")}
+	if code := RunOnce(zap.NewNop(), gatherer, newTestCache(t), conf); code != exitError {
+		t.Errorf("got exit code %d, want %d", code, exitError)
+	}
+
+	// Another run holds the lock.
+	lock := filepath.Join(dir, "transmission.lock")
+	unlock, err := lockFile(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf = TextfileConfig{Once: true, Output: filepath.Join(dir, "transmission.prom"), LockFile: lock}
+	if code := RunOnce(zap.NewNop(), gatherer, newTestCache(t), conf); code != exitLocked {
+		t.Errorf("got exit code %d, want %d", code, exitLocked)
+	}
+	if _, err := os.Stat(conf.Output); !os.IsNotExist(err) {
+		t.Errorf("metrics written while locked: %v", err)
+	}
+	unlock()
+	if code := RunOnce(zap.NewNop(), gatherer, newTestCache(t), conf); code != exitOK {
+		t.Errorf("got exit code %d after unlocking, want %d", code, exitOK)
+	}
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "transmission.prom")
+	if err := writeMetricsFile(path, testFamilies(1)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("got mode %s, want readable by the textfile collector", info.Mode())
+	}
+
+	// A failed write leaves the previous metrics in place, and no temporary file behind.
+	invalid := []*dto.MetricFamily{{Name: proto.String("transmission_empty"), Type: dto.MetricType_GAUGE.Enum()}}
+	if err := writeMetricsFile(path, invalid); err == nil {
+		t.Error("got no error writing a family without metrics")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "transmission_up 1\n") {
+		t.Errorf("got metrics\n%s\nwant the previous ones", b)
+	}
+	if files := dirFiles(t, dir); len(files) != 1 {
+		t.Errorf("got files %v, want only the output", files)
+	}
+
+	// A failed rename doesn't leave a temporary file behind either.
+	target := filepath.Join(dir, "dir.prom")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMetricsFile(target, testFamilies(1)); err == nil {
+		t.Error("got no error replacing a directory")
+	}
+	if files := dirFiles(t, dir); len(files) != 2 {
+		t.Errorf("got files %v, want only the output and the directory", files)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// torrentGrouping describes a way of grouping torrents for aggregated metrics
+type torrentGrouping struct {
+	// field is the torrent-get field needed for grouping
+	field string
+	// keys returns the groups a torrent belongs to
+	keys func(t *transmission.Torrent) []string
+}
+
+// torrentGroupings contains all supported groupings, by the name of the label holding the group
+var torrentGroupings = map[string]torrentGrouping{
+	"download_dir": {
+		field: "downloadDir",
+		keys:  func(t *transmission.Torrent) []string { return []string{t.DownloadDir} },
+	},
+	"label": {
+		field: "labels",
+		keys: func(t *transmission.Torrent) []string {
+			if len(t.Labels) == 0 {
+				return []string{""}
+			}
+			return t.Labels
+		},
+	},
+	"tracker": {
+		field: "trackers",
+		keys:  func(t *transmission.Torrent) []string { return []string{t.PrimaryTrackerHost()} },
+	},
+}
+
+// ValidateTorrentGroupings checks that all of the given grouping names are supported
+func ValidateTorrentGroupings(groupings []string) error {
+	for _, g := range groupings {
+		if _, ok := torrentGroupings[g]; !ok {
+			return fmt.Errorf("unknown torrent grouping %q", g)
+		}
+	}
+	return nil
+}
+
+// torrentAggregate holds the aggregated values of a group of torrents
+type torrentAggregate struct {
+	statusCounts   map[int]int
+	totalSize      int64
+	leftUntilDone  int64
+	rateDownload   int64
+	rateUpload     int64
+	uploadedEver   int64
+	downloadedEver int64
+	ratioSum       float64
+	ratioCount     int
+}
+
+func (a *torrentAggregate) add(t *transmission.Torrent) {
+	a.statusCounts[t.Status]++
+	a.totalSize += t.TotalSize
+	a.leftUntilDone += t.LeftUntilDone
+	a.rateDownload += int64(t.RateDownload)
+	a.rateUpload += int64(t.RateUpload)
+	a.uploadedEver += t.UploadedEver
+	a.downloadedEver += t.DownloadedEver
+
+	// Transmission uses negative ratios for "not available" and "infinite".
+	if t.UploadRatio >= 0 {
+		a.ratioSum += t.UploadRatio
+		a.ratioCount++
+	}
+}
+
+// torrentAggregateMetrics holds the metrics of a single grouping
+type torrentAggregateMetrics struct {
+	Torrents       *Metric
+	Size           *Metric
+	LeftUntilDone  *Metric
+	Download       *Metric
+	Upload         *Metric
+	UploadedEver   *Metric
+	DownloadedEver *Metric
+	Ratio          *Metric
+}
+
+// aggregateMetrics contains the metrics of all groupings, by grouping name
+var aggregateMetrics = make(map[string]*torrentAggregateMetrics)
+
+func init() {
+	groupings := make([]string, 0, len(torrentGroupings))
+	for g := range torrentGroupings {
+		groupings = append(groupings, g)
+	}
+	sort.Strings(groupings)
+
+	for _, g := range groupings {
+		collector := "torrent_aggregate_" + g
+		aggregateMetrics[g] = &torrentAggregateMetrics{
+			Torrents: registerMetric(&Metric{
+				Name:      g + "_torrents",
+				Help:      "The number of torrents by status",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g, "status"},
+				Collector: collector,
+				Fields:    []string{"status"},
+			}),
+			Size: registerMetric(&Metric{
+				Name:      g + "_size_bytes",
+				Help:      "The total size of the torrents in bytes",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"totalSize"},
+				Unit:      "bytes",
+			}),
+			LeftUntilDone: registerMetric(&Metric{
+				Name:      g + "_left_until_done_bytes",
+				Help:      "The amount of bytes left to download for the torrents",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"leftUntilDone"},
+				Unit:      "bytes",
+			}),
+			Download: registerMetric(&Metric{
+				Name:      g + "_download_bytes",
+				Help:      "The current download rate of the torrents in bytes",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"rateDownload"},
+				Unit:      "Bps",
+			}),
+			Upload: registerMetric(&Metric{
+				Name:      g + "_upload_bytes",
+				Help:      "The current upload rate of the torrents in bytes",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"rateUpload"},
+				Unit:      "Bps",
+			}),
+			UploadedEver: registerMetric(&Metric{
+				Name:      g + "_uploaded_ever_bytes",
+				Help:      "The amount of bytes that have been uploaded from the torrents ever",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"uploadedEver"},
+				Unit:      "bytes",
+			}),
+			DownloadedEver: registerMetric(&Metric{
+				Name:      g + "_downloaded_ever_bytes",
+				Help:      "The amount of bytes that have been downloaded from the torrents ever",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"downloadedEver"},
+				Unit:      "bytes",
+			}),
+			Ratio: registerMetric(&Metric{
+				Name:      g + "_ratio_avg",
+				Help:      "The average upload ratio of the torrents",
+				Type:      prometheus.GaugeValue,
+				Labels:    []string{g},
+				Collector: collector,
+				Fields:    []string{"uploadRatio"},
+			}),
+		}
+	}
+}
+
+// torrentAggregation holds the enabled metrics of a single grouping
+type torrentAggregation struct {
+	grouping torrentGrouping
+	metrics  *torrentAggregateMetrics
+	descs    metricDescs
+}
+
+// TorrentAggregateCollector exposes metrics aggregated over groups of torrents
+type TorrentAggregateCollector struct {
+	logger *zap.Logger
+	cache  *TorrentCache
+
+	groupings map[string]*torrentAggregation
+}
+
+// NewTorrentAggregateCollector creates a collector aggregating the torrents of the TorrentCache by
+// each of the given groupings. Groupings must have been checked with ValidateTorrentGroupings.
+func NewTorrentAggregateCollector(logger *zap.Logger, cache *TorrentCache, groupings []string) *TorrentAggregateCollector {
+	ac := &TorrentAggregateCollector{
+		logger:    logger,
+		cache:     cache,
+		groupings: make(map[string]*torrentAggregation),
+	}
+
+	for _, g := range groupings {
+		a := &torrentAggregation{
+			grouping: torrentGroupings[g],
+			metrics:  aggregateMetrics[g],
+			descs:    newMetricDescs("torrent_aggregate_"+g, nil),
+		}
+		if len(a.descs) == 0 {
+			continue
+		}
+
+		cache.AddFields(a.grouping.field)
+		cache.AddFields(a.descs.Fields()...)
+		ac.groupings[g] = a
+	}
+
+	return ac
+}
+
+// Describe implements the prometheus.Collector interface
+func (ac *TorrentAggregateCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, a := range ac.groupings {
+		a.descs.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface
+func (ac *TorrentAggregateCollector) Collect(ch chan<- prometheus.Metric) {
+	torrents, err := ac.cache.Torrents()
+	if err != nil {
+		ac.logger.Error("Failed to get torrents from Transmission.", zap.Error(err))
+		return
+	}
+
+	for _, a := range ac.groupings {
+		aggregates := make(map[string]*torrentAggregate)
+		for i := range torrents {
+			for _, key := range a.grouping.keys(&torrents[i]) {
+				agg, ok := aggregates[key]
+				if !ok {
+					agg = &torrentAggregate{statusCounts: make(map[int]int)}
+					aggregates[key] = agg
+				}
+				agg.add(&torrents[i])
+			}
+		}
+
+		for key, agg := range aggregates {
+			for status := transmission.StatusStopped; status <= transmission.StatusSeed; status++ {
+				a.descs.Collect(ch, a.metrics.Torrents, float64(agg.statusCounts[status]), key, transmission.StatusName(status))
+			}
+
+			var ratio float64
+			if agg.ratioCount > 0 {
+				ratio = agg.ratioSum / float64(agg.ratioCount)
+			}
+
+			a.descs.Collect(ch, a.metrics.Size, float64(agg.totalSize), key)
+			a.descs.Collect(ch, a.metrics.LeftUntilDone, float64(agg.leftUntilDone), key)
+			a.descs.Collect(ch, a.metrics.Download, float64(agg.rateDownload), key)
+			a.descs.Collect(ch, a.metrics.Upload, float64(agg.rateUpload), key)
+			a.descs.Collect(ch, a.metrics.UploadedEver, float64(agg.uploadedEver), key)
+			a.descs.Collect(ch, a.metrics.DownloadedEver, float64(agg.downloadedEver), key)
+			a.descs.Collect(ch, a.metrics.Ratio, ratio, key)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestTorrentAggregateCollector(t *testing.T) {
+	tracker := func(host string) []transmission.Tracker {
+		return []transmission.Tracker{{Announce: "https://" + host + "/announce"}}
+	}
+	cache := newTestCache(t,
+		transmission.Torrent{
+			ID: 1, DownloadDir: "/data", Labels: []string{"linux", "iso"}, Trackers: tracker("a.example.org"),
+			Status: transmission.StatusSeed, TotalSize: 100, UploadRatio: 2, UploadedEver: 200, DownloadedEver: 100,
+		},
+		transmission.Torrent{
+			ID: 2, DownloadDir: "/data", Labels: []string{"linux"}, Trackers: tracker("b.example.org"),
+			Status: transmission.StatusDownload, TotalSize: 50, LeftUntilDone: 40, RateDownload: 1000, UploadRatio: 0.5,
+		},
+		// A ratio of -1 is unknown, so it doesn't count towards the average.
+		transmission.Torrent{
+			ID: 3, DownloadDir: "/media", Trackers: tracker("a.example.org"),
+			Status: transmission.StatusStopped, TotalSize: 10, UploadRatio: -1,
+		},
+	)
+	ac := NewTorrentAggregateCollector(zap.NewNop(), cache, []string{"download_dir", "label", "tracker"})
+
+	expected := `
+# HELP transmission_download_dir_ratio_avg The average upload ratio of the torrents
+# TYPE transmission_download_dir_ratio_avg gauge
+transmission_download_dir_ratio_avg{download_dir="/data"} 1.25
+transmission_download_dir_ratio_avg{download_dir="/media"} 0
+# HELP transmission_download_dir_size_bytes The total size of the torrents in bytes
+# TYPE transmission_download_dir_size_bytes gauge
+transmission_download_dir_size_bytes{download_dir="/data"} 150
+transmission_download_dir_size_bytes{download_dir="/media"} 10
+# HELP transmission_label_download_bytes The current download rate of the torrents in bytes
+# TYPE transmission_label_download_bytes gauge
+transmission_label_download_bytes{label=""} 0
+transmission_label_download_bytes{label="iso"} 0
+transmission_label_download_bytes{label="linux"} 1000
+# HELP transmission_label_size_bytes The total size of the torrents in bytes
+# TYPE transmission_label_size_bytes gauge
+transmission_label_size_bytes{label=""} 10
+transmission_label_size_bytes{label="iso"} 100
+transmission_label_size_bytes{label="linux"} 150
+# HELP transmission_tracker_left_until_done_bytes The amount of bytes left to download for the torrents
+# TYPE transmission_tracker_left_until_done_bytes gauge
+transmission_tracker_left_until_done_bytes{tracker="a.example.org"} 0
+transmission_tracker_left_until_done_bytes{tracker="b.example.org"} 40
+# HELP transmission_tracker_ratio_avg The average upload ratio of the torrents
+# TYPE transmission_tracker_ratio_avg gauge
+transmission_tracker_ratio_avg{tracker="a.example.org"} 2
+transmission_tracker_ratio_avg{tracker="b.example.org"} 0.5
+# HELP transmission_tracker_uploaded_ever_bytes The amount of bytes that have been uploaded from the torrents ever
+# TYPE transmission_tracker_uploaded_ever_bytes gauge
+transmission_tracker_uploaded_ever_bytes{tracker="a.example.org"} 200
+transmission_tracker_uploaded_ever_bytes{tracker="b.example.org"} 0
+`
+	if err := testutil.CollectAndCompare(ac, strings.NewReader(expected),
+		"transmission_download_dir_ratio_avg",
+		"transmission_download_dir_size_bytes",
+		"transmission_label_download_bytes",
+		"transmission_label_size_bytes",
+		"transmission_tracker_left_until_done_bytes",
+		"transmission_tracker_ratio_avg",
+		"transmission_tracker_uploaded_ever_bytes",
+	); err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP transmission_label_torrents The number of torrents by status
+# TYPE transmission_label_torrents gauge
+transmission_label_torrents{label="",status="check"} 0
+transmission_label_torrents{label="",status="check_wait"} 0
+transmission_label_torrents{label="",status="download"} 0
+transmission_label_torrents{label="",status="download_wait"} 0
+transmission_label_torrents{label="",status="seed"} 0
+transmission_label_torrents{label="",status="seed_wait"} 0
+transmission_label_torrents{label="",status="stopped"} 1
+transmission_label_torrents{label="iso",status="check"} 0
+transmission_label_torrents{label="iso",status="check_wait"} 0
+transmission_label_torrents{label="iso",status="download"} 0
+transmission_label_torrents{label="iso",status="download_wait"} 0
+transmission_label_torrents{label="iso",status="seed"} 1
+transmission_label_torrents{label="iso",status="seed_wait"} 0
+transmission_label_torrents{label="iso",status="stopped"} 0
+transmission_label_torrents{label="linux",status="check"} 0
+transmission_label_torrents{label="linux",status="check_wait"} 0
+transmission_label_torrents{label="linux",status="download"} 1
+transmission_label_torrents{label="linux",status="download_wait"} 0
+transmission_label_torrents{label="linux",status="seed"} 1
+transmission_label_torrents{label="linux",status="seed_wait"} 0
+transmission_label_torrents{label="linux",status="stopped"} 0
+`
+	if err := testutil.CollectAndCompare(ac, strings.NewReader(expected), "transmission_label_torrents"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateTorrentGroupings(t *testing.T) {
+	if err := ValidateTorrentGroupings([]string{"download_dir", "label", "tracker"}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateTorrentGroupings([]string{"label", "category"}); err == nil {
+		t.Error("got no error for an unknown grouping")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// torrentCacheMaxAge is how long fetched torrents are reused before asking Transmission again, so
+// that the collectors sharing a TorrentCache only cause a single request per scrape
+const torrentCacheMaxAge = time.Second
+
+// TorrentUpdate describes the state of the cached torrents after an update from Transmission
+type TorrentUpdate struct {
+	Time time.Time
+	// Torrents are the torrents matching the filter
+	Torrents []transmission.Torrent
+	// All are all torrents, whether they match the filter or not
+	All []transmission.Torrent
+}
+
+// TorrentCache keeps the state of all torrents. After the first successful request, only
+// recently active torrents are fetched from Transmission and merged into the cached state.
+type TorrentCache struct {
+	logger *zap.Logger
+	client *transmission.Client
+	filter *TorrentFilter
+
+	fields     []string
+	seenFields map[string]bool
+	observers  []func(TorrentUpdate)
+
+	torrentMap         map[int]transmission.Torrent
+	recentlyActiveOnly bool
+	updated            time.Time
+	err                error
+	lock               sync.Mutex
+}
+
+// NewTorrentCache creates a TorrentCache fetching torrents with the transmission.Client, only
+// returning the torrents matching filter, or all of them if filter is nil
+func NewTorrentCache(logger *zap.Logger, client *transmission.Client, filter *TorrentFilter) *TorrentCache {
+	c := &TorrentCache{
+		logger:     logger,
+		client:     client,
+		filter:     filter,
+		seenFields: make(map[string]bool),
+		torrentMap: make(map[int]transmission.Torrent),
+	}
+	if filter != nil {
+		c.AddFields(filter.Fields()...)
+	}
+	return c
+}
+
+// AddFields adds torrent-get fields to request. It must be called before the first call to
+// Torrents.
+func (c *TorrentCache) AddFields(fields ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, field := range fields {
+		if !c.seenFields[field] {
+			c.fields = append(c.fields, field)
+			c.seenFields[field] = true
+		}
+	}
+}
+
+// Observe registers a function that gets called with the torrents matching the filter after every
+// successful update. It is called with the cache locked, so it must not call back into the cache.
+func (c *TorrentCache) Observe(observer func(TorrentUpdate)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.observers = append(c.observers, observer)
+}
+
+// Torrents returns the current state of all torrents matching the filter
+func (c *TorrentCache) Torrents() ([]transmission.Torrent, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if time.Since(c.updated) >= torrentCacheMaxAge {
+		c.err = c.update()
+		c.updated = time.Now()
+
+		if c.err == nil && len(c.observers) > 0 {
+			update := TorrentUpdate{Time: c.updated, Torrents: c.filtered(), All: c.all()}
+			for _, observer := range c.observers {
+				observer(update)
+			}
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.filtered(), nil
+}
+
+// LastUpdate returns the time of the last update from Transmission, and its error if it failed
+func (c *TorrentCache) LastUpdate() (time.Time, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.updated, c.err
+}
+
+// Match reports whether the torrent matches the filter of the cache
+func (c *TorrentCache) Match(t *transmission.Torrent) bool {
+	return c.filter == nil || c.filter.Match(t)
+}
+
+// filtered returns the cached torrents matching the filter
+func (c *TorrentCache) filtered() []transmission.Torrent {
+	torrents := make([]transmission.Torrent, 0, len(c.torrentMap))
+	for _, t := range c.torrentMap {
+		if c.Match(&t) {
+			torrents = append(torrents, t)
+		}
+	}
+	return torrents
+}
+
+// all returns all cached torrents
+func (c *TorrentCache) all() []transmission.Torrent {
+	torrents := make([]transmission.Torrent, 0, len(c.torrentMap))
+	for _, t := range c.torrentMap {
+		torrents = append(torrents, t)
+	}
+	return torrents
+}
+
+func (c *TorrentCache) update() error {
+	// Update our map of cached torrents, both adding any new torrents as well as deleting any
+	// removed torrents. Torrents are streamed into the map, so that the response is never held in
+	// memory as a whole. A failed update may have updated some of the torrents, each of which is
+	// still complete.
+	fetched := 0
+	removed, err := c.client.StreamTorrents(c.recentlyActiveOnly, func(t *transmission.Torrent) error {
+		c.torrentMap[t.ID] = *t
+		fetched++
+		return nil
+	}, c.fields...)
+	if err != nil {
+		return err
+	}
+	for _, id := range removed {
+		delete(c.torrentMap, id)
+	}
+
+	c.logger.Debug("Fetched torrents from Transmission.",
+		zap.Bool("recently_active_only", c.recentlyActiveOnly),
+		zap.Int("torrents", fetched),
+		zap.Int("removed", len(removed)),
+	)
+
+	if len(c.torrentMap) > 0 {
+		c.recentlyActiveOnly = true // only do this if successful
+	}
+	return nil
+}
+
+// Poll keeps the cache up to date by updating it at the given interval, independently of scrapes.
+// It never returns.
+func (c *TorrentCache) Poll(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := c.Torrents(); err != nil {
+			c.logger.Error("Failed to poll torrents from Transmission.", zap.Error(err))
+		}
+	}
+}
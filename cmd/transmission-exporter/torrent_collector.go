@@ -1,250 +1,495 @@
 package main
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	transmission "github.com/tobz/transmission-exporter"
 	"go.uber.org/zap"
 )
 
-const (
-	namespace string = "transmission_"
-)
+// torrentLabel describes a label that can be attached to every torrent metric
+type torrentLabel struct {
+	// field is the torrent-get field needed for this label
+	field string
+	value func(t *transmission.Torrent) string
+}
+
+// torrentLabels contains all labels that can be attached to torrent metrics, by label name
+var torrentLabels = map[string]torrentLabel{
+	"id": {
+		field: "id",
+		value: func(t *transmission.Torrent) string { return strconv.Itoa(t.ID) },
+	},
+	"name": {
+		field: "name",
+		value: func(t *transmission.Torrent) string { return t.Name },
+	},
+	"hash": {
+		field: "hashString",
+		value: func(t *transmission.Torrent) string { return t.HashString },
+	},
+	"download_dir": {
+		field: "downloadDir",
+		value: func(t *transmission.Torrent) string { return t.DownloadDir },
+	},
+	"labels": {
+		field: "labels",
+		value: func(t *transmission.Torrent) string {
+			labels := append([]string(nil), t.Labels...)
+			sort.Strings(labels)
+			return strings.Join(labels, ",")
+		},
+	},
+	"tracker": {
+		field: "trackers",
+		value: func(t *transmission.Torrent) string { return t.PrimaryTrackerHost() },
+	},
+	"is_private": {
+		field: "isPrivate",
+		value: func(t *transmission.Torrent) string { return NumericBool(t.IsPrivate) },
+	},
+	"queue_position": {
+		field: "queuePosition",
+		value: func(t *transmission.Torrent) string { return strconv.Itoa(t.QueuePosition) },
+	},
+	"creator": {
+		field: "creator",
+		value: func(t *transmission.Torrent) string { return t.Creator },
+	},
+	"comment_host": {
+		field: "comment",
+		value: func(t *transmission.Torrent) string { return t.CommentHost() },
+	},
+	"magnet_link": {
+		field: "magnetLink",
+		value: func(t *transmission.Torrent) string { return NumericBool(t.MagnetLink != "") },
+	},
+}
+
+// torrentInfoLabels are the labels of the torrent info metric, which carries the high-cardinality
+// metadata of a torrent so that the other torrent metrics only need to be labeled by hash
+var torrentInfoLabels = []string{
+	"hash",
+	"name",
+	"download_dir",
+	"labels",
+	"is_private",
+	"creator",
+	"comment_host",
+	"magnet_link",
+}
+
+// torrentMetric describes a metric exported for every torrent
+type torrentMetric struct {
+	name   string
+	help   string
+	unit   string
+	fields []string
+	// cumulative marks totals that only grow, see Metric.Cumulative
+	cumulative bool
+
+	// value returns the value of the metric for a torrent
+	value func(t *transmission.Torrent) float64
+	// exists, if set, reports whether the metric has a meaningful value for a torrent at all
+	exists func(t *transmission.Torrent) bool
+
+	// label and values, if set instead of value, add a label to the metric that is set to the
+	// keys of the map returned by values, resulting in one sample per key
+	label  string
+	values func(t *transmission.Torrent) map[string]float64
+
+	// metric is the registered metric, set when the package is initialized
+	metric *Metric
+}
+
+// torrentMetrics contains all metrics exported for every torrent
+var torrentMetrics = []torrentMetric{
+	{
+		name:   "status",
+		help:   "Status of a torrent",
+		fields: []string{"status"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.Status) },
+	},
+	{
+		name:   "added",
+		help:   "The unixtime time a torrent was added",
+		unit:   "dateTimeFromNow",
+		fields: []string{"addedDate"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.Added) },
+	},
+	{
+		name:   "finished",
+		help:   "Indicates if a torrent is finished (1) or not (0)",
+		fields: []string{"isFinished"},
+		value: func(t *transmission.Torrent) float64 {
+			v, _ := strconv.ParseFloat(NumericBool(t.IsFinished), 64)
+			return v
+		},
+	},
+	{
+		name:   "done",
+		help:   "The percent of a torrent being done",
+		unit:   "percentunit",
+		fields: []string{"percentDone"},
+		value:  func(t *transmission.Torrent) float64 { return t.PercentDone },
+	},
+	{
+		name:   "ratio",
+		help:   "The upload ratio of a torrent",
+		fields: []string{"uploadRatio"},
+		value:  func(t *transmission.Torrent) float64 { return t.UploadRatio },
+	},
+	{
+		name:   "download_bytes",
+		help:   "The current download rate of a torrent in bytes",
+		unit:   "Bps",
+		fields: []string{"rateDownload"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.RateDownload) },
+	},
+	{
+		name:   "upload_bytes",
+		help:   "The current upload rate of a torrent in bytes",
+		unit:   "Bps",
+		fields: []string{"rateUpload"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.RateUpload) },
+	},
+	{
+		name:       "uploaded_ever_bytes",
+		help:       "The amount of bytes that have been uploaded from a torrent ever",
+		unit:       "bytes",
+		fields:     []string{"uploadedEver"},
+		cumulative: true,
+		value:      func(t *transmission.Torrent) float64 { return float64(t.UploadedEver) },
+	},
+	{
+		name:       "downloaded_ever_bytes",
+		help:       "The amount of bytes that have been downloaded from a torrent ever",
+		unit:       "bytes",
+		fields:     []string{"downloadedEver"},
+		cumulative: true,
+		value:      func(t *transmission.Torrent) float64 { return float64(t.DownloadedEver) },
+	},
+	{
+		name:   "peers_connected",
+		help:   "The quantity of peers connected on a torrent",
+		fields: []string{"peersConnected"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.PeersConnected) },
+	},
+	{
+		name:   "peers_getting_from_us",
+		help:   "The quantity of peers getting pieces of a torrent from us",
+		fields: []string{"peersGettingFromUs"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.PeersGettingFromUs) },
+	},
+	{
+		name:   "peers_sending_to_us",
+		help:   "The quantity of peers sending pieces of a torrent to us",
+		fields: []string{"peersSendingToUs"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.PeersSendingToUs) },
+	},
+	{
+		// Transmission reports -1 if the ETA is not available and -2 if it is unknown.
+		name:   "eta_seconds",
+		help:   "The estimated time until a torrent is done downloading or reaches its seed ratio in seconds",
+		unit:   "s",
+		fields: []string{"eta"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.Eta) },
+		exists: func(t *transmission.Torrent) bool { return t.Eta >= 0 },
+	},
+	{
+		name:   "size_bytes",
+		help:   "The total size of a torrent in bytes",
+		unit:   "bytes",
+		fields: []string{"totalSize"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.TotalSize) },
+	},
+	{
+		name:   "size_when_done_bytes",
+		help:   "The size of the wanted files of a torrent in bytes",
+		unit:   "bytes",
+		fields: []string{"sizeWhenDone"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.SizeWhenDone) },
+	},
+	{
+		name:   "have_valid_bytes",
+		help:   "The amount of bytes of a torrent that have been downloaded and verified",
+		unit:   "bytes",
+		fields: []string{"haveValid"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.HaveValid) },
+	},
+	{
+		name:   "have_unchecked_bytes",
+		help:   "The amount of bytes of a torrent that have been downloaded but not verified yet",
+		unit:   "bytes",
+		fields: []string{"haveUnchecked"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.HaveUnchecked) },
+	},
+	{
+		name:   "desired_available_bytes",
+		help:   "The amount of bytes of a torrent that are still wanted and available from connected peers",
+		unit:   "bytes",
+		fields: []string{"desiredAvailable"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.DesiredAvailable) },
+	},
+	{
+		name:       "corrupt_ever_bytes",
+		help:       "The amount of corrupt bytes that have been downloaded for a torrent ever",
+		unit:       "bytes",
+		fields:     []string{"corruptEver"},
+		cumulative: true,
+		value:      func(t *transmission.Torrent) float64 { return float64(t.CorruptEver) },
+	},
+	{
+		name:       "downloading_seconds",
+		help:       "The time a torrent has spent downloading in seconds",
+		unit:       "s",
+		fields:     []string{"secondsDownloading"},
+		cumulative: true,
+		value:      func(t *transmission.Torrent) float64 { return float64(t.SecondsDownloading) },
+	},
+	{
+		name:       "seeding_seconds",
+		help:       "The time a torrent has spent seeding in seconds",
+		unit:       "s",
+		fields:     []string{"secondsSeeding"},
+		cumulative: true,
+		value:      func(t *transmission.Torrent) float64 { return float64(t.SecondsSeeding) },
+	},
+	{
+		name:   "activity_date",
+		help:   "The unixtime of the last activity of a torrent",
+		unit:   "dateTimeFromNow",
+		fields: []string{"activityDate"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.ActivityDate) },
+	},
+	{
+		name:   "done_date",
+		help:   "The unixtime a torrent finished downloading, or 0 if it hasn't",
+		unit:   "dateTimeFromNow",
+		fields: []string{"doneDate"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.DoneDate) },
+	},
+	{
+		name:   "start_date",
+		help:   "The unixtime a torrent was last started",
+		unit:   "dateTimeFromNow",
+		fields: []string{"startDate"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.StartDate) },
+	},
+	{
+		name:   "queue_position",
+		help:   "The position of a torrent in its queue",
+		fields: []string{"queuePosition"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.QueuePosition) },
+	},
+	{
+		name:   "stalled",
+		help:   "Indicates if a torrent is stalled (1) or not (0)",
+		fields: []string{"isStalled"},
+		value: func(t *transmission.Torrent) float64 {
+			v, _ := strconv.ParseFloat(NumericBool(t.IsStalled), 64)
+			return v
+		},
+	},
+	{
+		name:   "metadata_done",
+		help:   "The percent of a torrent's metadata being done",
+		unit:   "percentunit",
+		fields: []string{"metadataPercentComplete"},
+		value:  func(t *transmission.Torrent) float64 { return t.MetadataPercent },
+	},
+	{
+		name:   "webseeds_sending_to_us",
+		help:   "The quantity of webseeds sending pieces of a torrent to us",
+		fields: []string{"webseedsSendingToUs"},
+		value:  func(t *transmission.Torrent) float64 { return float64(t.WebseedsSendingToUs) },
+	},
+	{
+		name:   "error",
+		help:   "The error of a torrent: none (0), tracker warning (1), tracker error (2) or local error (3)",
+		fields: []string{"error", "errorString"},
+		label:  "reason",
+		values: func(t *transmission.Torrent) map[string]float64 {
+			return map[string]float64{ErrorReason(t.Error, t.ErrorString): float64(t.Error)}
+		},
+	},
+	{
+		name:   "peers_from",
+		help:   "The quantity of peers connected on a torrent by how they were discovered",
+		fields: []string{"peersFrom"},
+		label:  "source",
+		values: func(t *transmission.Torrent) map[string]float64 {
+			return map[string]float64{
+				"cache":    float64(t.PeersFrom.FromCache),
+				"dht":      float64(t.PeersFrom.FromDHT),
+				"incoming": float64(t.PeersFrom.FromIncoming),
+				"lpd":      float64(t.PeersFrom.FromLPD),
+				"ltep":     float64(t.PeersFrom.FromLTEP),
+				"pex":      float64(t.PeersFrom.FromPEX),
+				"tracker":  float64(t.PeersFrom.FromTracker),
+			}
+		},
+	},
+}
+
+var torrentInfo = registerMetric(&Metric{
+	Name:      "torrent_info",
+	Help:      "Metadata of a torrent as labels",
+	Type:      prometheus.GaugeValue,
+	Labels:    torrentInfoLabels,
+	Collector: "torrent_info",
+	Fields:    torrentLabelFields(torrentInfoLabels),
+	NoPanel:   true,
+})
+
+func init() {
+	for i := range torrentMetrics {
+		m := &torrentMetrics[i]
+		var labels []string
+		if m.label != "" {
+			labels = []string{m.label}
+		}
+		m.metric = registerMetric(&Metric{
+			Name:       "torrent_" + m.name,
+			Help:       m.help,
+			Type:       prometheus.GaugeValue,
+			Labels:     labels,
+			Collector:  "torrent",
+			Fields:     m.fields,
+			Unit:       m.unit,
+			PerTorrent: true,
+			Cumulative: m.cumulative,
+		})
+	}
+}
+
+// TorrentCollectorOptions configures which labels and metrics a TorrentCollector exports
+type TorrentCollectorOptions struct {
+	// Labels are attached to every torrent metric, and must have been checked with ValidateTorrentLabels
+	Labels []string
+	// Info enables the torrent info metric
+	Info bool
+}
+
+// ValidateTorrentLabels checks that all of the given label names are supported torrent labels, that
+// they identify a torrent with its id or hash, since torrents with the same labels would make up
+// duplicate series, and that the hash label is present when the info metric is enabled so the two
+// can be joined
+func ValidateTorrentLabels(labels []string, info bool) error {
+	seen := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		if _, ok := torrentLabels[l]; !ok {
+			return fmt.Errorf("unknown torrent label %q", l)
+		}
+		if seen[l] {
+			return fmt.Errorf("duplicate torrent label %q", l)
+		}
+		seen[l] = true
+	}
+	if !seen["id"] && !seen["hash"] {
+		return fmt.Errorf("torrent labels must include %q or %q to tell torrents apart", "id", "hash")
+	}
+	if info && !seen["hash"] {
+		return fmt.Errorf("torrent label %q is required with the torrent info metric", "hash")
+	}
+	return nil
+}
 
-// TorrentCollector has a transmission.Client to create torrent metrics
+// TorrentCollector has a TorrentCache to create torrent metrics
 type TorrentCollector struct {
 	logger *zap.Logger
-	client *transmission.Client
-
-	Status             *prometheus.Desc
-	Added              *prometheus.Desc
-	Finished           *prometheus.Desc
-	Done               *prometheus.Desc
-	Ratio              *prometheus.Desc
-	Download           *prometheus.Desc
-	Upload             *prometheus.Desc
-	UploadedEver       *prometheus.Desc
-	DownloadedEver     *prometheus.Desc
-	PeersConnected     *prometheus.Desc
-	PeersGettingFromUs *prometheus.Desc
-	PeersSendingToUs   *prometheus.Desc
-
-	recentlyActiveOnly bool
-
-	torrentMap     map[int]transmission.Torrent
-	torrentMapLock sync.Mutex
+	cache  *TorrentCache
+
+	metrics []torrentMetric
+	descs   metricDescs
+	info    metricDescs
+
+	labels []string
 }
 
-// NewTorrentCollector creates a new torrent collector with the transmission.Client
-func NewTorrentCollector(logger *zap.Logger, client *transmission.Client) *TorrentCollector {
-	const collectorNamespace = "torrent_"
-
-	return &TorrentCollector{
-		torrentMap: make(map[int]transmission.Torrent),
-		logger:     logger,
-		client:     client,
-
-		Status: prometheus.NewDesc(
-			namespace+collectorNamespace+"status",
-			"Status of a torrent",
-			[]string{"id", "name"},
-			nil,
-		),
-		Added: prometheus.NewDesc(
-			namespace+collectorNamespace+"added",
-			"The unixtime time a torrent was added",
-			[]string{"id", "name"},
-			nil,
-		),
-		Finished: prometheus.NewDesc(
-			namespace+collectorNamespace+"finished",
-			"Indicates if a torrent is finished (1) or not (0)",
-			[]string{"id", "name"},
-			nil,
-		),
-		Done: prometheus.NewDesc(
-			namespace+collectorNamespace+"done",
-			"The percent of a torrent being done",
-			[]string{"id", "name"},
-			nil,
-		),
-		Ratio: prometheus.NewDesc(
-			namespace+collectorNamespace+"ratio",
-			"The upload ratio of a torrent",
-			[]string{"id", "name"},
-			nil,
-		),
-		Download: prometheus.NewDesc(
-			namespace+collectorNamespace+"download_bytes",
-			"The current download rate of a torrent in bytes",
-			[]string{"id", "name"},
-			nil,
-		),
-		Upload: prometheus.NewDesc(
-			namespace+collectorNamespace+"upload_bytes",
-			"The current upload rate of a torrent in bytes",
-			[]string{"id", "name"},
-			nil,
-		),
-		UploadedEver: prometheus.NewDesc(
-			namespace+collectorNamespace+"uploaded_ever_bytes",
-			"The amount of bytes that have been uploaded from a torrent ever",
-			[]string{"id", "name"},
-			nil,
-		),
-		DownloadedEver: prometheus.NewDesc(
-			namespace+collectorNamespace+"downloaded_ever_bytes",
-			"The amount of bytes that have been downloaded from a torrent ever",
-			[]string{"id", "name"},
-			nil,
-		),
-		PeersConnected: prometheus.NewDesc(
-			namespace+collectorNamespace+"peers_connected",
-			"The quantity of peers connected on a torrent",
-			[]string{"id", "name"},
-			nil,
-		),
-		PeersGettingFromUs: prometheus.NewDesc(
-			namespace+collectorNamespace+"peers_getting_from_us",
-			"The quantity of peers getting pieces of a torrent from us",
-			[]string{"id", "name"},
-			nil,
-		),
-		PeersSendingToUs: prometheus.NewDesc(
-			namespace+collectorNamespace+"peers_sending_to_us",
-			"The quantity of peers sending pieces of a torrent to us",
-			[]string{"id", "name"},
-			nil,
-		),
+// NewTorrentCollector creates a new torrent collector with the TorrentCache
+func NewTorrentCollector(logger *zap.Logger, cache *TorrentCache, opts TorrentCollectorOptions) *TorrentCollector {
+	labels := opts.Labels
+	cache.AddFields(torrentLabelFields(labels)...)
+
+	tc := &TorrentCollector{
+		logger: logger,
+		cache:  cache,
+		labels: labels,
 	}
+
+	// Only the fields of the enabled metrics get requested from Transmission.
+	tc.descs = newMetricDescs("torrent", labels)
+	cache.AddFields(tc.descs.Fields()...)
+	for _, m := range torrentMetrics {
+		if tc.descs.Has(m.metric) {
+			tc.metrics = append(tc.metrics, m)
+		}
+	}
+
+	if opts.Info {
+		tc.info = newMetricDescs("torrent_info", nil)
+		cache.AddFields(tc.info.Fields()...)
+	}
+
+	return tc
 }
 
 // Describe implements the prometheus.Collector interface
 func (tc *TorrentCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- tc.Status
-	ch <- tc.Added
-	ch <- tc.Finished
-	ch <- tc.Done
-	ch <- tc.Ratio
-	ch <- tc.Download
-	ch <- tc.Upload
-	ch <- tc.UploadedEver
-	ch <- tc.DownloadedEver
-	ch <- tc.PeersConnected
-	ch <- tc.PeersGettingFromUs
-	ch <- tc.PeersSendingToUs
+	tc.descs.Describe(ch)
+	tc.info.Describe(ch)
 }
 
 // Collect implements the prometheus.Collector interface
 func (tc *TorrentCollector) Collect(ch chan<- prometheus.Metric) {
-	response, err := tc.client.GetTorrents(tc.recentlyActiveOnly)
+	torrents, err := tc.cache.Torrents()
 	if err != nil {
 		tc.logger.Error("Failed to get torrents from Transmission.", zap.Error(err))
 		return
 	}
 
-	var activeTorrents []transmission.Torrent
+	for _, t := range torrents {
+		labelValues := torrentLabelValues(tc.labels, &t)
 
-	// Update our map of cached torrents, both adding any new torrents as well as deleting any
-	// removed torrents. We'll create a new list after doing that to iterate over for metrics.
-	tc.torrentMapLock.Lock()
-	for _, t := range response.Torrents {
-		tc.torrentMap[t.ID] = t
-	}
-	for _, id := range response.RemovedTorrents {
-		delete(tc.torrentMap, id)
-	}
-	for _, t := range tc.torrentMap {
-		activeTorrents = append(activeTorrents, t)
-	}
-	tc.torrentMapLock.Unlock()
-
-	if len(activeTorrents) > 0 {
-		tc.recentlyActiveOnly = true // only do this if successful
-	}
-
-	for _, t := range activeTorrents {
-		var finished float64
+		if tc.info.Has(torrentInfo) {
+			tc.info.Collect(ch, torrentInfo, 1, torrentLabelValues(torrentInfoLabels, &t)...)
+		}
 
-		id := strconv.Itoa(t.ID)
+		for _, m := range tc.metrics {
+			if m.exists != nil && !m.exists(&t) {
+				continue
+			}
+			if m.values == nil {
+				tc.descs.Collect(ch, m.metric, m.value(&t), labelValues...)
+				continue
+			}
 
-		if t.IsFinished {
-			finished = 1
+			for key, value := range m.values(&t) {
+				tc.descs.Collect(ch, m.metric, value, append(labelValues, key)...)
+			}
 		}
+	}
+}
+
+// torrentLabelFields returns the torrent-get fields needed for the given labels
+func torrentLabelFields(labels []string) []string {
+	fields := make([]string, len(labels))
+	for i, l := range labels {
+		fields[i] = torrentLabels[l].field
+	}
+	return fields
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			tc.Status,
-			prometheus.GaugeValue,
-			float64(t.Status),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Added,
-			prometheus.GaugeValue,
-			float64(t.Added),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Finished,
-			prometheus.GaugeValue,
-			finished,
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Done,
-			prometheus.GaugeValue,
-			t.PercentDone,
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Ratio,
-			prometheus.GaugeValue,
-			t.UploadRatio,
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Download,
-			prometheus.GaugeValue,
-			float64(t.RateDownload),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Upload,
-			prometheus.GaugeValue,
-			float64(t.RateUpload),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.UploadedEver,
-			prometheus.GaugeValue,
-			float64(t.UploadedEver),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.DownloadedEver,
-			prometheus.GaugeValue,
-			float64(t.DownloadedEver),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.PeersConnected,
-			prometheus.GaugeValue,
-			float64(t.PeersConnected),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.PeersGettingFromUs,
-			prometheus.GaugeValue,
-			float64(t.PeersGettingFromUs),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.PeersSendingToUs,
-			prometheus.GaugeValue,
-			float64(t.PeersSendingToUs),
-			id, t.Name,
-		)
+// torrentLabelValues returns the values of the given labels for the given torrent
+func torrentLabelValues(labels []string, t *transmission.Torrent) []string {
+	values := make([]string, len(labels))
+	for i, l := range labels {
+		values[i] = torrentLabels[l].value(t)
 	}
+	return values
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestTorrentCollectorETA(t *testing.T) {
+	// Transmission reports -1 if the ETA is not available and -2 if it is unknown.
+	cache := newTestCache(t,
+		transmission.Torrent{ID: 1, Eta: 3600},
+		transmission.Torrent{ID: 2, Eta: 0},
+		transmission.Torrent{ID: 3, Eta: -1},
+		transmission.Torrent{ID: 4, Eta: -2},
+	)
+	tc := NewTorrentCollector(zap.NewNop(), cache, TorrentCollectorOptions{Labels: []string{"id"}})
+
+	expected := `
+# HELP transmission_torrent_eta_seconds The estimated time until a torrent is done downloading or reaches its seed ratio in seconds
+# TYPE transmission_torrent_eta_seconds gauge
+transmission_torrent_eta_seconds{id="1"} 3600
+transmission_torrent_eta_seconds{id="2"} 0
+`
+	if err := testutil.CollectAndCompare(tc, strings.NewReader(expected), "transmission_torrent_eta_seconds"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// errorReason is a normalized reason for a torrent error, recognized by substrings of the
+// lowercased error string
+type errorReason struct {
+	reason   string
+	patterns []string
+}
+
+// errorReasons is the bounded vocabulary of error reasons, in order of precedence. Errors that
+// don't match any of them are reported as "other".
+var errorReasons = []errorReason{
+	{"unregistered_torrent", []string{"unregistered", "not registered", "torrent not found", "unknown torrent", "torrent does not exist", "infohash not found"}},
+	{"no_space_left", []string{"no space left", "disk full"}},
+	{"permission_denied", []string{"permission denied", "access is denied", "operation not permitted"}},
+	{"read_only_filesystem", []string{"read-only file system"}},
+	{"file_not_found", []string{"no such file or directory", "no data found"}},
+	{"io_error", []string{"input/output error", "i/o error"}},
+	{"unauthorized", []string{"passkey", "unauthorized", "forbidden", "not authorized"}},
+	{"timed_out", []string{"timed out", "timeout"}},
+	{"dns_failure", []string{"could not resolve", "name or service not known", "no address associated"}},
+	{"connection_failed", []string{"could not connect", "connection refused", "connection failed", "connection reset", "network is unreachable"}},
+	{"tracker_unavailable", []string{"service unavailable", "bad gateway", "gateway time", "internal server error", "tracker is down", "maintenance"}},
+}
+
+// ErrorReason returns the normalized reason of a torrent error from its code and error string,
+// "none" for torrents without an error and "other" for an empty or unrecognized error string
+func ErrorReason(code int, errorString string) string {
+	if code == transmission.ErrorNone {
+		return "none"
+	}
+
+	s := strings.ToLower(errorString)
+	for _, r := range errorReasons {
+		for _, p := range r.patterns {
+			if strings.Contains(s, p) {
+				return r.reason
+			}
+		}
+	}
+	return "other"
+}
+
+var (
+	torrentsErrored = registerMetric(&Metric{
+		Name:      "torrents_errored",
+		Help:      "The number of torrents with an error by class and normalized reason",
+		Type:      prometheus.GaugeValue,
+		Labels:    []string{"class", "reason"},
+		Collector: "torrent_error",
+		Fields:    []string{"error", "errorString"},
+	})
+)
+
+// TorrentErrorCollector exposes the number of errored torrents
+type TorrentErrorCollector struct {
+	logger *zap.Logger
+	cache  *TorrentCache
+
+	descs metricDescs
+}
+
+// NewTorrentErrorCollector creates a collector counting the errored torrents of the TorrentCache
+func NewTorrentErrorCollector(logger *zap.Logger, cache *TorrentCache) *TorrentErrorCollector {
+	ec := &TorrentErrorCollector{
+		logger: logger,
+		cache:  cache,
+
+		descs: newMetricDescs("torrent_error", nil),
+	}
+	cache.AddFields(ec.descs.Fields()...)
+
+	return ec
+}
+
+// Describe implements the prometheus.Collector interface
+func (ec *TorrentErrorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ec.descs.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface
+func (ec *TorrentErrorCollector) Collect(ch chan<- prometheus.Metric) {
+	torrents, err := ec.cache.Torrents()
+	if err != nil {
+		ec.logger.Error("Failed to get torrents from Transmission.", zap.Error(err))
+		return
+	}
+
+	type errorKey struct {
+		code   int
+		reason string
+	}
+	counts := make(map[errorKey]int)
+	for _, t := range torrents {
+		if t.Error != transmission.ErrorNone {
+			counts[errorKey{t.Error, ErrorReason(t.Error, t.ErrorString)}]++
+		}
+	}
+
+	// Every combination is exported, so that alerts can rely on the series being present.
+	reasons := make([]string, 0, len(errorReasons)+1)
+	for _, r := range errorReasons {
+		reasons = append(reasons, r.reason)
+	}
+	reasons = append(reasons, "other")
+
+	for code := transmission.ErrorTrackerWarning; code <= transmission.ErrorLocalError; code++ {
+		for _, reason := range reasons {
+			ec.descs.Collect(ch, torrentsErrored, float64(counts[errorKey{code, reason}]), transmission.ErrorName(code), reason)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestErrorReason(t *testing.T) {
+	for _, test := range []struct {
+		code        int
+		errorString string
+		reason      string
+	}{
+		{transmission.ErrorNone, "", "none"},
+		{transmission.ErrorNone, "Unregistered torrent", "none"},
+		{transmission.ErrorTrackerError, "", "other"},
+		{transmission.ErrorTrackerError, "Something went wrong", "other"},
+
+		{transmission.ErrorTrackerError, "Unregistered torrent", "unregistered_torrent"},
+		{transmission.ErrorTrackerError, "Torrent not registered with this tracker", "unregistered_torrent"},
+		{transmission.ErrorTrackerError, "Tracker gave HTTP response code 404 (torrent not found)", "unregistered_torrent"},
+		{transmission.ErrorTrackerError, "infohash not found.", "unregistered_torrent"},
+		{transmission.ErrorLocalError, "No space left on device (/data/ubuntu.iso)", "no_space_left"},
+		{transmission.ErrorLocalError, "Permission denied (/data/ubuntu.iso)", "permission_denied"},
+		{transmission.ErrorLocalError, "Read-only file system (/data)", "read_only_filesystem"},
+		{transmission.ErrorLocalError, "No data found! Ensure your drives are connected or use \"Set Location\".", "file_not_found"},
+		{transmission.ErrorLocalError, "No such file or directory (/data/ubuntu.iso)", "file_not_found"},
+		{transmission.ErrorLocalError, "Input/output error (/data/ubuntu.iso)", "io_error"},
+		{transmission.ErrorTrackerError, "Invalid passkey", "unauthorized"},
+		{transmission.ErrorTrackerError, "Tracker gave HTTP response code 403 (Forbidden)", "unauthorized"},
+		{transmission.ErrorTrackerWarning, "Tracker did not respond", "other"},
+		{transmission.ErrorTrackerWarning, "Connection timed out", "timed_out"},
+		{transmission.ErrorTrackerWarning, "Could not resolve host: tracker.example.org", "dns_failure"},
+		{transmission.ErrorTrackerWarning, "Could not connect to tracker", "connection_failed"},
+		{transmission.ErrorTrackerWarning, "Connection refused", "connection_failed"},
+		{transmission.ErrorTrackerWarning, "Tracker gave HTTP response code 503 (Service Unavailable)", "tracker_unavailable"},
+		{transmission.ErrorTrackerWarning, "Tracker gave HTTP response code 502 (Bad Gateway)", "tracker_unavailable"},
+
+		// The first reason in order of precedence wins.
+		{transmission.ErrorTrackerError, "Unregistered torrent: passkey revoked", "unregistered_torrent"},
+		{transmission.ErrorTrackerWarning, "Could not connect to tracker: connection timed out", "timed_out"},
+	} {
+		if got := ErrorReason(test.code, test.errorString); got != test.reason {
+			t.Errorf("%d %q: got reason %s, want %s", test.code, test.errorString, got, test.reason)
+		}
+	}
+}
+
+func TestTorrentErrorCollector(t *testing.T) {
+	cache := newTestCache(t,
+		transmission.Torrent{ID: 1, Error: transmission.ErrorTrackerError, ErrorString: "Unregistered torrent"},
+		transmission.Torrent{ID: 2, Error: transmission.ErrorTrackerError, ErrorString: "unregistered torrent"},
+		transmission.Torrent{ID: 3, Error: transmission.ErrorLocalError, ErrorString: "Something went wrong"},
+		transmission.Torrent{ID: 4, Error: transmission.ErrorNone},
+	)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(NewTorrentErrorCollector(zap.NewNop(), cache))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 {
+		t.Fatalf("got %d metric families, want 1", len(families))
+	}
+
+	// Every combination of class and reason is exported, even without errors.
+	got := make(map[string]float64)
+	for _, m := range families[0].GetMetric() {
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		got[labels["class"]+"/"+labels["reason"]] = m.GetGauge().GetValue()
+	}
+	if want := 3 * (len(errorReasons) + 1); len(got) != want {
+		t.Errorf("got %d series, want %d", len(got), want)
+	}
+	for series, want := range map[string]float64{
+		"tracker_error/unregistered_torrent":   2,
+		"local_error/other":                    1,
+		"tracker_warning/unregistered_torrent": 0,
+		"tracker_error/other":                  0,
+	} {
+		if value, ok := got[series]; !ok || value != want {
+			t.Errorf("%s: got %g, want %g", series, value, want)
+		}
+	}
+	if _, ok := got["none/none"]; ok {
+		t.Error("torrents without an error are counted")
+	}
+}
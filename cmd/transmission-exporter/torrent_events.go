@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// Types of torrent events
+const (
+	EventAdded            = "added"
+	EventMetadataResolved = "metadata_resolved"
+	EventCompleted        = "completed"
+	EventStarted          = "started"
+	EventStopped          = "stopped"
+	EventErrored          = "errored"
+	EventRecovered        = "recovered"
+	EventRemoved          = "removed"
+)
+
+// EventTypes contains all types of torrent events
+var EventTypes = []string{
+	EventAdded,
+	EventMetadataResolved,
+	EventCompleted,
+	EventStarted,
+	EventStopped,
+	EventErrored,
+	EventRecovered,
+	EventRemoved,
+}
+
+// subscriberBufferSize is the number of events buffered for each subscriber before new events are
+// dropped for it
+const subscriberBufferSize = 256
+
+// TorrentEvent is a transition of a torrent between two cache updates
+type TorrentEvent struct {
+	Seq         uint64    `json:"seq"`
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Hash        string    `json:"hash"`
+	DownloadDir string    `json:"downloadDir"`
+	Error       int       `json:"error,omitempty"`
+	ErrorString string    `json:"errorString,omitempty"`
+
+	// Torrent is the state of the torrent after the event, or before it if it was removed
+	Torrent transmission.Torrent `json:"-"`
+}
+
+var (
+	torrentEvents = registerMetric(&Metric{
+		Name:      "torrent_events_total",
+		Help:      "The number of torrent events by type",
+		Type:      prometheus.CounterValue,
+		Labels:    []string{"type"},
+		Collector: "torrent_events",
+	})
+)
+
+// TorrentEvents detects events by diffing successive cache updates, and publishes them to the log,
+// a bounded history, subscribers and per-type counters
+type TorrentEvents struct {
+	logger *zap.Logger
+	match  func(*transmission.Torrent) bool
+
+	previous map[int]transmission.Torrent
+	seeded   bool
+
+	history     []TorrentEvent
+	historySize int
+	seq         uint64
+	counts      map[string]uint64
+	subscribers map[chan TorrentEvent]bool
+	lock        sync.Mutex
+
+	descs metricDescs
+}
+
+// NewTorrentEvents creates a TorrentEvents following the updates of the TorrentCache, keeping the
+// last historySize events
+func NewTorrentEvents(logger *zap.Logger, cache *TorrentCache, historySize int) (*TorrentEvents, error) {
+	if historySize < 0 {
+		return nil, fmt.Errorf("invalid event history size %d", historySize)
+	}
+
+	cache.AddFields(
+		"name",
+		"hashString",
+		"downloadDir",
+		"status",
+		"percentDone",
+		"metadataPercentComplete",
+		"error",
+		"errorString",
+	)
+
+	te := &TorrentEvents{
+		logger:      logger,
+		match:       cache.Match,
+		previous:    make(map[int]transmission.Torrent),
+		historySize: historySize,
+		counts:      make(map[string]uint64),
+		subscribers: make(map[chan TorrentEvent]bool),
+
+		descs: newMetricDescs("torrent_events", nil),
+	}
+	cache.Observe(te.update)
+
+	return te, nil
+}
+
+// update diffs the torrents against those of the previous update and publishes the resulting events.
+// All torrents are diffed, so that torrents entering or leaving the filter, e.g. by changing their
+// status, don't look added or removed, and events are published for torrents matching the filter
+// before or after the change.
+func (te *TorrentEvents) update(u TorrentUpdate) {
+	current := make(map[int]transmission.Torrent, len(u.All))
+	for _, t := range u.All {
+		current[t.ID] = t
+	}
+
+	// The first update only establishes the state to diff against.
+	if !te.seeded {
+		te.previous = current
+		te.seeded = true
+		return
+	}
+
+	for id, t := range current {
+		prev, ok := te.previous[id]
+		if !ok {
+			if te.match(&t) {
+				te.publish(EventAdded, u.Time, t)
+			}
+			continue
+		}
+		if !te.match(&prev) && !te.match(&t) {
+			continue
+		}
+
+		if prev.MetadataPercent < 1 && t.MetadataPercent >= 1 {
+			te.publish(EventMetadataResolved, u.Time, t)
+		}
+		if prev.PercentDone < 1 && t.PercentDone >= 1 {
+			te.publish(EventCompleted, u.Time, t)
+		}
+		if prev.Status == transmission.StatusStopped && t.Status != transmission.StatusStopped {
+			te.publish(EventStarted, u.Time, t)
+		}
+		if prev.Status != transmission.StatusStopped && t.Status == transmission.StatusStopped {
+			te.publish(EventStopped, u.Time, t)
+		}
+		if prev.Error == transmission.ErrorNone && t.Error != transmission.ErrorNone {
+			te.publish(EventErrored, u.Time, t)
+		}
+		if prev.Error != transmission.ErrorNone && t.Error == transmission.ErrorNone {
+			te.publish(EventRecovered, u.Time, t)
+		}
+	}
+	for id, prev := range te.previous {
+		if _, ok := current[id]; !ok && te.match(&prev) {
+			te.publish(EventRemoved, u.Time, prev)
+		}
+	}
+
+	te.previous = current
+}
+
+func (te *TorrentEvents) publish(eventType string, at time.Time, t transmission.Torrent) {
+	te.lock.Lock()
+	defer te.lock.Unlock()
+
+	te.seq++
+	event := TorrentEvent{
+		Seq:         te.seq,
+		Type:        eventType,
+		Time:        at,
+		ID:          t.ID,
+		Name:        t.Name,
+		Hash:        t.HashString,
+		DownloadDir: t.DownloadDir,
+		Error:       t.Error,
+		ErrorString: t.ErrorString,
+		Torrent:     t,
+	}
+
+	te.logger.Info("Torrent event.",
+		zap.String("type", event.Type),
+		zap.Int("id", event.ID),
+		zap.String("name", event.Name),
+		zap.String("hash", event.Hash),
+		zap.String("download_dir", event.DownloadDir),
+		zap.String("error", event.ErrorString),
+	)
+
+	te.counts[eventType]++
+
+	te.history = append(te.history, event)
+	if len(te.history) > te.historySize {
+		te.history = te.history[len(te.history)-te.historySize:]
+	}
+
+	for ch := range te.subscribers {
+		select {
+		case ch <- event:
+		default:
+			te.logger.Warn("Dropped torrent event for slow subscriber.", zap.Uint64("seq", event.Seq))
+		}
+	}
+}
+
+// History returns the kept events with a sequence number greater than after, oldest first
+func (te *TorrentEvents) History(after uint64) []TorrentEvent {
+	te.lock.Lock()
+	defer te.lock.Unlock()
+
+	events := []TorrentEvent{}
+	for _, e := range te.history {
+		if e.Seq > after {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Subscribe returns a channel receiving all events published from now on, and a function to
+// cancel the subscription
+func (te *TorrentEvents) Subscribe() (<-chan TorrentEvent, func()) {
+	te.lock.Lock()
+	defer te.lock.Unlock()
+
+	ch := make(chan TorrentEvent, subscriberBufferSize)
+	te.subscribers[ch] = true
+
+	return ch, func() {
+		te.lock.Lock()
+		defer te.lock.Unlock()
+
+		delete(te.subscribers, ch)
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (te *TorrentEvents) Describe(ch chan<- *prometheus.Desc) {
+	te.descs.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface
+func (te *TorrentEvents) Collect(ch chan<- prometheus.Metric) {
+	te.lock.Lock()
+	defer te.lock.Unlock()
+
+	for _, eventType := range EventTypes {
+		te.descs.Collect(ch, torrentEvents, float64(te.counts[eventType]), eventType)
+	}
+}
+
+// HistoryHandler lists the kept events as JSON, optionally only those after the sequence number
+// given in the "after" query parameter
+func (te *TorrentEvents) HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var after uint64
+		if s := r.URL.Query().Get("after"); s != "" {
+			var err error
+			if after, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "invalid after parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(te.History(after))
+	})
+}
+
+// StreamHandler streams events as Server-Sent Events. Clients reconnecting with a Last-Event-ID
+// header first receive the kept events they missed.
+func (te *TorrentEvents) StreamHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events, cancel := te.Subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		var last uint64
+		if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			for _, e := range te.History(id) {
+				writeEvent(w, e)
+				last = e.Seq
+			}
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-events:
+				if e.Seq <= last {
+					continue
+				}
+				writeEvent(w, e)
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func writeEvent(w http.ResponseWriter, e TorrentEvent) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+}
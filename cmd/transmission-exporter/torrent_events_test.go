@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestTorrentEventsUpdate(t *testing.T) {
+	const (
+		stopped  = transmission.StatusStopped
+		download = transmission.StatusDownload
+		seed     = transmission.StatusSeed
+	)
+	torrent := func(id, status int, metadata, done float64, errorCode int) transmission.Torrent {
+		return transmission.Torrent{ID: id, Status: status, MetadataPercent: metadata, PercentDone: done, Error: errorCode}
+	}
+
+	// Only downloading and seeding torrents match the filter, so starting and stopping torrents
+	// moves them in and out of it.
+	filter, err := NewTorrentFilter(TorrentFilterConfig{IncludeStatuses: []string{"download", "seed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newTestCache(t)
+	cache.filter = filter
+	te, err := NewTorrentEvents(zap.NewNop(), cache, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every update is followed by the events it is expected to publish, as type and torrent id.
+	for i, step := range []struct {
+		torrents []transmission.Torrent
+		events   []string
+	}{
+		// The first update only establishes the state.
+		{
+			[]transmission.Torrent{
+				torrent(1, download, 0.5, 0, 0),
+				torrent(2, stopped, 1, 0, 0),
+				torrent(3, seed, 1, 1, 0),
+			},
+			nil,
+		},
+		{
+			[]transmission.Torrent{
+				torrent(1, download, 1, 0, 0),
+				torrent(2, stopped, 1, 0, 0),
+				torrent(3, seed, 1, 1, 0),
+				torrent(4, stopped, 1, 0, 0),
+				torrent(5, download, 1, 0, 0),
+			},
+			[]string{"added 5", "metadata_resolved 1"},
+		},
+		{
+			[]transmission.Torrent{
+				torrent(1, seed, 1, 1, 0),
+				torrent(2, download, 1, 0, 0),
+				torrent(3, stopped, 1, 1, 0),
+				torrent(4, stopped, 1, 0, 0),
+				torrent(5, download, 1, 0, 0),
+			},
+			// Torrents entering or leaving the filter get events rather than look added or removed.
+			[]string{"completed 1", "started 2", "stopped 3"},
+		},
+		{
+			[]transmission.Torrent{
+				torrent(1, seed, 1, 1, transmission.ErrorTrackerWarning),
+				torrent(2, download, 1, 0, 0),
+				torrent(3, stopped, 1, 1, transmission.ErrorLocalError),
+			},
+			// Torrents that don't match the filter before or after get none.
+			[]string{"errored 1", "removed 5"},
+		},
+		{
+			[]transmission.Torrent{
+				torrent(1, seed, 1, 1, 0),
+				torrent(2, download, 1, 0, 0),
+				torrent(3, stopped, 1, 1, 0),
+			},
+			[]string{"recovered 1"},
+		},
+	} {
+		last := te.seq
+		te.update(TorrentUpdate{Time: time.Now(), All: step.torrents})
+
+		var events []string
+		for _, e := range te.History(last) {
+			events = append(events, fmt.Sprintf("%s %d", e.Type, e.ID))
+		}
+		// Events of the same update come in the random order of the torrents.
+		sort.Strings(events)
+		if !reflect.DeepEqual(events, step.events) {
+			t.Errorf("update %d: got events %q, want %q", i, events, step.events)
+		}
+	}
+}
+
+func TestTorrentEventsHistory(t *testing.T) {
+	te := newTestEvents(t)
+	te.historySize = 2
+	for id := 1; id <= 3; id++ {
+		te.publish(EventAdded, time.Now(), transmission.Torrent{ID: id})
+	}
+
+	for after, want := range map[uint64][]uint64{0: {2, 3}, 2: {3}, 3: {}} {
+		var seqs []uint64
+		for _, e := range te.History(after) {
+			seqs = append(seqs, e.Seq)
+		}
+		if fmt.Sprint(seqs) != fmt.Sprint(want) {
+			t.Errorf("after %d: got events %v, want %v", after, seqs, want)
+		}
+	}
+}
+
+// readEvents reads n Server-Sent Events, returning their ids and types
+func readEvents(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var events []string
+	var id string
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch line = strings.TrimSuffix(line, "\n"); {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, id+" "+strings.TrimPrefix(line, "event: "))
+		}
+	}
+	return events
+}
+
+func TestTorrentEventsStream(t *testing.T) {
+	for _, test := range []struct {
+		lastEventID string
+		replayed    []string
+	}{
+		{"", nil},
+		{"invalid", nil},
+		{"1", []string{"2 started", "3 completed"}},
+		{"3", nil},
+	} {
+		te := newTestEvents(t)
+		server := httptest.NewServer(te.StreamHandler())
+		for _, eventType := range []string{EventAdded, EventStarted, EventCompleted} {
+			te.publish(eventType, time.Now(), transmission.Torrent{ID: 1})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", test.lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("got content type %q", ct)
+		}
+
+		// The stream is subscribed once the response started, so new events follow the replayed
+		// ones.
+		te.publish(EventStopped, time.Now(), transmission.Torrent{ID: 1})
+		want := append(test.replayed, "4 stopped")
+		if got := readEvents(t, bufio.NewReader(resp.Body), len(want)); !reflect.DeepEqual(got, want) {
+			t.Errorf("Last-Event-ID %q: got events %q, want %q", test.lastEventID, got, want)
+		}
+
+		resp.Body.Close()
+		cancel()
+		server.Close()
+	}
+}
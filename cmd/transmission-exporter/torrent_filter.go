@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	transmission "github.com/tobz/transmission-exporter"
+)
+
+// TorrentFilterConfig holds the configuration of a TorrentFilter
+type TorrentFilterConfig struct {
+	IncludeName     string   `arg:"--include-name,env:INCLUDE_NAME" help:"only export torrents whose name matches this regular expression"`
+	ExcludeName     string   `arg:"--exclude-name,env:EXCLUDE_NAME" help:"don't export torrents whose name matches this regular expression"`
+	IncludeDirs     []string `arg:"--include-dir,env:INCLUDE_DIRS" help:"only export torrents whose download dir starts with one of these prefixes"`
+	ExcludeDirs     []string `arg:"--exclude-dir,env:EXCLUDE_DIRS" help:"don't export torrents whose download dir starts with one of these prefixes"`
+	IncludeLabels   []string `arg:"--include-label,env:INCLUDE_LABELS" help:"only export torrents that have one of these labels"`
+	ExcludeLabels   []string `arg:"--exclude-label,env:EXCLUDE_LABELS" help:"don't export torrents that have one of these labels"`
+	IncludeTrackers []string `arg:"--include-tracker,env:INCLUDE_TRACKERS" help:"only export torrents announcing to one of these tracker hosts"`
+	ExcludeTrackers []string `arg:"--exclude-tracker,env:EXCLUDE_TRACKERS" help:"don't export torrents announcing to one of these tracker hosts"`
+	IncludeStatuses []string `arg:"--include-status,env:INCLUDE_STATUSES" help:"only export torrents in one of these statuses: stopped, check_wait, check, download_wait, download, seed_wait, seed"`
+	ExcludeStatuses []string `arg:"--exclude-status,env:EXCLUDE_STATUSES" help:"don't export torrents in one of these statuses"`
+	MinSize         int64    `arg:"--min-size,env:MIN_SIZE" help:"only export torrents of at least this many bytes"`
+}
+
+// TorrentFilter decides which torrents get exported. A torrent has to match every configured
+// include condition and none of the configured exclude conditions.
+type TorrentFilter struct {
+	includeName     *regexp.Regexp
+	excludeName     *regexp.Regexp
+	includeDirs     []string
+	excludeDirs     []string
+	includeLabels   map[string]bool
+	excludeLabels   map[string]bool
+	includeTrackers map[string]bool
+	excludeTrackers map[string]bool
+	includeStatuses map[int]bool
+	excludeStatuses map[int]bool
+	minSize         int64
+}
+
+// NewTorrentFilter creates a TorrentFilter from its configuration
+func NewTorrentFilter(conf TorrentFilterConfig) (*TorrentFilter, error) {
+	var err error
+	f := &TorrentFilter{
+		includeDirs:     SplitList(conf.IncludeDirs),
+		excludeDirs:     SplitList(conf.ExcludeDirs),
+		includeLabels:   stringSet(SplitList(conf.IncludeLabels)),
+		excludeLabels:   stringSet(SplitList(conf.ExcludeLabels)),
+		includeTrackers: stringSet(SplitList(conf.IncludeTrackers)),
+		excludeTrackers: stringSet(SplitList(conf.ExcludeTrackers)),
+		minSize:         conf.MinSize,
+	}
+
+	if conf.IncludeName != "" {
+		if f.includeName, err = regexp.Compile(conf.IncludeName); err != nil {
+			return nil, fmt.Errorf("invalid name include pattern: %w", err)
+		}
+	}
+	if conf.ExcludeName != "" {
+		if f.excludeName, err = regexp.Compile(conf.ExcludeName); err != nil {
+			return nil, fmt.Errorf("invalid name exclude pattern: %w", err)
+		}
+	}
+	if f.includeStatuses, err = statusSet(SplitList(conf.IncludeStatuses)); err != nil {
+		return nil, err
+	}
+	if f.excludeStatuses, err = statusSet(SplitList(conf.ExcludeStatuses)); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Fields returns the torrent-get fields the filter needs
+func (f *TorrentFilter) Fields() []string {
+	var fields []string
+	if f.includeName != nil || f.excludeName != nil {
+		fields = append(fields, "name")
+	}
+	if len(f.includeDirs) > 0 || len(f.excludeDirs) > 0 {
+		fields = append(fields, "downloadDir")
+	}
+	if len(f.includeLabels) > 0 || len(f.excludeLabels) > 0 {
+		fields = append(fields, "labels")
+	}
+	if len(f.includeTrackers) > 0 || len(f.excludeTrackers) > 0 {
+		fields = append(fields, "trackers")
+	}
+	if len(f.includeStatuses) > 0 || len(f.excludeStatuses) > 0 {
+		fields = append(fields, "status")
+	}
+	if f.minSize > 0 {
+		fields = append(fields, "totalSize")
+	}
+	return fields
+}
+
+// Match returns whether the torrent should be exported
+func (f *TorrentFilter) Match(t *transmission.Torrent) bool {
+	if f.includeName != nil && !f.includeName.MatchString(t.Name) {
+		return false
+	}
+	if f.excludeName != nil && f.excludeName.MatchString(t.Name) {
+		return false
+	}
+	if len(f.includeDirs) > 0 && !hasAnyPrefix(t.DownloadDir, f.includeDirs) {
+		return false
+	}
+	if hasAnyPrefix(t.DownloadDir, f.excludeDirs) {
+		return false
+	}
+	if len(f.includeLabels) > 0 && !containsAny(f.includeLabels, t.Labels) {
+		return false
+	}
+	if containsAny(f.excludeLabels, t.Labels) {
+		return false
+	}
+	if len(f.includeTrackers) > 0 || len(f.excludeTrackers) > 0 {
+		hosts := t.TrackerHosts()
+		if len(f.includeTrackers) > 0 && !containsAny(f.includeTrackers, hosts) {
+			return false
+		}
+		if containsAny(f.excludeTrackers, hosts) {
+			return false
+		}
+	}
+	if len(f.includeStatuses) > 0 && !f.includeStatuses[t.Status] {
+		return false
+	}
+	if f.excludeStatuses[t.Status] {
+		return false
+	}
+	return t.TotalSize >= f.minSize
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func statusSet(names []string) (map[int]bool, error) {
+	set := make(map[int]bool, len(names))
+	for _, name := range names {
+		status, err := transmission.ParseStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		set[status] = true
+	}
+	return set, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(set map[string]bool, values []string) bool {
+	for _, v := range values {
+		if set[v] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/tobz/transmission-exporter"
+)
+
+func TestTorrentFilterMatch(t *testing.T) {
+	torrent := transmission.Torrent{
+		Name:        "ubuntu-22.04.iso",
+		DownloadDir: "/data/linux",
+		Labels:      []string{"os", "linux"},
+		Trackers: []transmission.Tracker{
+			{Announce: "https://torrent.ubuntu.com/announce"},
+			{Announce: "udp://tracker.example.org:6969/announce"},
+		},
+		Status:    transmission.StatusSeed,
+		TotalSize: 1 << 30,
+	}
+
+	for _, test := range []struct {
+		name  string
+		conf  TorrentFilterConfig
+		match bool
+	}{
+		{"no conditions", TorrentFilterConfig{}, true},
+
+		{"name included", TorrentFilterConfig{IncludeName: `^ubuntu-`}, true},
+		{"name not included", TorrentFilterConfig{IncludeName: `^debian-`}, false},
+		{"name excluded", TorrentFilterConfig{ExcludeName: `\.iso$`}, false},
+		{"name not excluded", TorrentFilterConfig{ExcludeName: `\.mkv$`}, true},
+
+		{"dir included", TorrentFilterConfig{IncludeDirs: []string{"/media", "/data/"}}, true},
+		{"dir not included", TorrentFilterConfig{IncludeDirs: []string{"/media"}}, false},
+		{"dir excluded", TorrentFilterConfig{ExcludeDirs: []string{"/data/linux"}}, false},
+
+		{"label included", TorrentFilterConfig{IncludeLabels: []string{"movies,linux"}}, true},
+		{"label not included", TorrentFilterConfig{IncludeLabels: []string{"movies", "tv"}}, false},
+		{"label excluded", TorrentFilterConfig{ExcludeLabels: []string{"os"}}, false},
+		{"label not excluded", TorrentFilterConfig{ExcludeLabels: []string{"movies"}}, true},
+		{"label included and excluded", TorrentFilterConfig{IncludeLabels: []string{"linux"}, ExcludeLabels: []string{"os"}}, false},
+
+		{"tracker included", TorrentFilterConfig{IncludeTrackers: []string{"tracker.example.org"}}, true},
+		{"tracker not included", TorrentFilterConfig{IncludeTrackers: []string{"tracker.example.com"}}, false},
+		{"tracker included by port", TorrentFilterConfig{IncludeTrackers: []string{"tracker.example.org:6969"}}, false},
+		{"tracker excluded", TorrentFilterConfig{ExcludeTrackers: []string{"torrent.ubuntu.com"}}, false},
+		{"tracker not excluded", TorrentFilterConfig{ExcludeTrackers: []string{"tracker.example.com"}}, true},
+
+		{"status included", TorrentFilterConfig{IncludeStatuses: []string{"download,seed"}}, true},
+		{"status not included", TorrentFilterConfig{IncludeStatuses: []string{"stopped"}}, false},
+		{"status excluded", TorrentFilterConfig{ExcludeStatuses: []string{"seed"}}, false},
+		{"status not excluded", TorrentFilterConfig{ExcludeStatuses: []string{"seed_wait"}}, true},
+
+		{"min size reached", TorrentFilterConfig{MinSize: 1 << 30}, true},
+		{"min size not reached", TorrentFilterConfig{MinSize: 1<<30 + 1}, false},
+
+		{"all conditions", TorrentFilterConfig{
+			IncludeName:     "ubuntu",
+			ExcludeDirs:     []string{"/tmp"},
+			IncludeLabels:   []string{"os"},
+			IncludeTrackers: []string{"torrent.ubuntu.com"},
+			ExcludeStatuses: []string{"stopped"},
+		}, true},
+	} {
+		f, err := NewTorrentFilter(test.conf)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := f.Match(&torrent); got != test.match {
+			t.Errorf("%s: got match %t, want %t", test.name, got, test.match)
+		}
+	}
+}
+
+func TestTorrentFilterFields(t *testing.T) {
+	f, err := NewTorrentFilter(TorrentFilterConfig{ExcludeLabels: []string{"os"}, IncludeStatuses: []string{"seed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Fields(); len(got) != 2 || got[0] != "labels" || got[1] != "status" {
+		t.Errorf("got fields %v, want [labels status]", got)
+	}
+}
+
+func TestTorrentFilterErrors(t *testing.T) {
+	for _, conf := range []TorrentFilterConfig{
+		{IncludeName: "("},
+		{ExcludeName: "[a-"},
+		{IncludeStatuses: []string{"seeding"}},
+		{ExcludeStatuses: []string{"seed,paused"}},
+	} {
+		if _, err := NewTorrentFilter(conf); err == nil {
+			t.Errorf("%+v: got no error", conf)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// rateSample is the aggregate download rate of a download dir at some point in time
+type rateSample struct {
+	time time.Time
+	rate int64
+}
+
+var (
+	forecastCompletionSeconds = registerMetric(&Metric{
+		Name:      "download_dir_completion_seconds",
+		Help:      "The forecasted time until all downloading and queued torrents in a download dir are done in seconds",
+		Type:      prometheus.GaugeValue,
+		Labels:    []string{"download_dir"},
+		Collector: "torrent_forecast",
+		Fields:    []string{"downloadDir", "status", "leftUntilDone", "rateDownload"},
+		Unit:      "s",
+	})
+)
+
+// TorrentForecastCollector forecasts when the downloads in each download dir will be done, based on
+// the bytes left and the average aggregate download rate seen over a recent window of scrapes
+type TorrentForecastCollector struct {
+	logger *zap.Logger
+	cache  *TorrentCache
+	window time.Duration
+
+	rates     map[string][]rateSample
+	ratesLock sync.Mutex
+
+	descs metricDescs
+}
+
+// NewTorrentForecastCollector creates a forecasting collector averaging the download rate of the
+// TorrentCache's torrents over the given window
+func NewTorrentForecastCollector(logger *zap.Logger, cache *TorrentCache, window time.Duration) *TorrentForecastCollector {
+	fc := &TorrentForecastCollector{
+		logger: logger,
+		cache:  cache,
+		window: window,
+		rates:  make(map[string][]rateSample),
+
+		descs: newMetricDescs("torrent_forecast", nil),
+	}
+	cache.AddFields(fc.descs.Fields()...)
+
+	return fc
+}
+
+// Describe implements the prometheus.Collector interface
+func (fc *TorrentForecastCollector) Describe(ch chan<- *prometheus.Desc) {
+	fc.descs.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface
+func (fc *TorrentForecastCollector) Collect(ch chan<- prometheus.Metric) {
+	torrents, err := fc.cache.Torrents()
+	if err != nil {
+		fc.logger.Error("Failed to get torrents from Transmission.", zap.Error(err))
+		return
+	}
+
+	left := make(map[string]int64)
+	rates := make(map[string]int64)
+	for _, t := range torrents {
+		if t.Status != transmission.StatusDownload && t.Status != transmission.StatusDownloadWait {
+			continue
+		}
+		left[t.DownloadDir] += t.LeftUntilDone
+		rates[t.DownloadDir] += int64(t.RateDownload)
+	}
+
+	now := time.Now()
+
+	fc.ratesLock.Lock()
+	defer fc.ratesLock.Unlock()
+
+	for dir, rate := range rates {
+		fc.rates[dir] = append(fc.rates[dir], rateSample{time: now, rate: rate})
+	}
+
+	for dir, samples := range fc.rates {
+		// Dirs without downloads still get samples of a zero rate until they leave the window,
+		// so the average reflects the recent past rather than only the scrapes with downloads.
+		if _, ok := rates[dir]; !ok {
+			samples = append(samples, rateSample{time: now})
+		}
+
+		i := 0
+		for i < len(samples) && now.Sub(samples[i].time) > fc.window {
+			i++
+		}
+		samples = samples[i:]
+
+		if _, ok := rates[dir]; !ok && allZero(samples) {
+			delete(fc.rates, dir)
+			continue
+		}
+		fc.rates[dir] = samples
+
+		var sum int64
+		for _, s := range samples {
+			sum += s.rate
+		}
+		avg := float64(sum) / float64(len(samples))
+
+		// Without any recent progress there is nothing to base a forecast on.
+		if avg <= 0 || left[dir] <= 0 {
+			continue
+		}
+
+		fc.descs.Collect(ch, forecastCompletionSeconds, float64(left[dir])/avg, dir)
+	}
+}
+
+func allZero(samples []rateSample) bool {
+	for _, s := range samples {
+		if s.rate != 0 {
+			return false
+		}
+	}
+	return true
+}
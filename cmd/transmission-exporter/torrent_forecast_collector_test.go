@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+func TestTorrentForecastCollector(t *testing.T) {
+	cache := newTestCache(t,
+		transmission.Torrent{ID: 1, DownloadDir: "/data", Status: transmission.StatusDownload, LeftUntilDone: 1000, RateDownload: 10},
+		transmission.Torrent{ID: 2, DownloadDir: "/data", Status: transmission.StatusDownloadWait, LeftUntilDone: 200},
+		// Only downloading and queued torrents are forecast.
+		transmission.Torrent{ID: 3, DownloadDir: "/data", Status: transmission.StatusStopped, LeftUntilDone: 5000},
+		transmission.Torrent{ID: 4, DownloadDir: "/media", Status: transmission.StatusSeed, RateDownload: 50},
+	)
+	fc := NewTorrentForecastCollector(zap.NewNop(), cache, 10*time.Minute)
+
+	now := time.Now()
+	fc.rates = map[string][]rateSample{
+		// Samples that left the window are pruned.
+		"/data": {{time: now.Add(-time.Hour), rate: 1000}},
+		// Dirs whose samples in the window are all zero are forgotten.
+		"/old": {{time: now.Add(-time.Hour), rate: 100}, {time: now.Add(-time.Minute)}},
+		// Dirs without downloads are kept until their rates leave the window.
+		"/recent": {{time: now.Add(-time.Minute), rate: 20}},
+	}
+
+	expected := `
+# HELP transmission_download_dir_completion_seconds The forecasted time until all downloading and queued torrents in a download dir are done in seconds
+# TYPE transmission_download_dir_completion_seconds gauge
+transmission_download_dir_completion_seconds{download_dir="/data"} 120
+`
+	if err := testutil.CollectAndCompare(fc, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	for dir, n := range map[string]int{"/data": 1, "/recent": 2} {
+		if len(fc.rates[dir]) != n {
+			t.Errorf("%s: got %d rate samples, want %d", dir, len(fc.rates[dir]), n)
+		}
+	}
+	if len(fc.rates) != 2 {
+		t.Errorf("got rates of %d dirs, want 2", len(fc.rates))
+	}
+
+	// The forecast is based on the average rate over the window.
+	torrent := cache.torrentMap[1]
+	torrent.RateDownload = 30
+	cache.torrentMap[1] = torrent
+	expected = `
+# HELP transmission_download_dir_completion_seconds The forecasted time until all downloading and queued torrents in a download dir are done in seconds
+# TYPE transmission_download_dir_completion_seconds gauge
+transmission_download_dir_completion_seconds{download_dir="/data"} 60
+`
+	if err := testutil.CollectAndCompare(fc, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
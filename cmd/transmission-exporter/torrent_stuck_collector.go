@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// Reasons for a torrent to be considered stuck
+const (
+	stuckNoProgress = "no_progress"
+	stuckNoMetadata = "no_metadata"
+	stuckCheckWait  = "check_wait"
+)
+
+var stuckReasons = []string{stuckNoProgress, stuckNoMetadata, stuckCheckWait}
+
+// stuckState tracks since when a torrent has been in each of the states that can get it stuck
+type stuckState struct {
+	torrent transmission.Torrent
+
+	noProgressSince time.Time
+	noMetadataSince time.Time
+	checkWaitSince  time.Time
+}
+
+// StuckTorrent is a torrent that has been stuck for longer than the configured period
+type StuckTorrent struct {
+	ID       int       `json:"id"`
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"`
+	Reason   string    `json:"reason"`
+	Since    time.Time `json:"since"`
+	Duration float64   `json:"durationSeconds"`
+
+	torrent *transmission.Torrent
+}
+
+var (
+	torrentsStuck = registerMetric(&Metric{
+		Name:      "torrents_stuck",
+		Help:      "The number of stuck torrents by reason",
+		Type:      prometheus.GaugeValue,
+		Labels:    []string{"reason"},
+		Collector: "torrent_stuck",
+	})
+	torrentStuckSeconds = registerMetric(&Metric{
+		Name:       "torrent_stuck_seconds",
+		Help:       "The time a stuck torrent has been stuck for in seconds",
+		Type:       prometheus.GaugeValue,
+		Labels:     []string{"reason"},
+		Collector:  "torrent_stuck",
+		Unit:       "s",
+		PerTorrent: true,
+	})
+)
+
+// TorrentStuckCollector detects torrents that are downloading without making progress, magnets
+// whose metadata never resolves and torrents waiting to be checked, by following the state of the
+// cached torrents across updates
+type TorrentStuckCollector struct {
+	logger *zap.Logger
+	cache  *TorrentCache
+	after  time.Duration
+	labels []string
+
+	states     map[int]*stuckState
+	updated    time.Time
+	statesLock sync.Mutex
+
+	descs metricDescs
+}
+
+// NewTorrentStuckCollector creates a collector reporting the torrents of the TorrentCache that have
+// been stuck for longer than after. Unless perTorrent is false, the time each torrent has been
+// stuck for is exported too, labeled with the given torrent labels.
+func NewTorrentStuckCollector(logger *zap.Logger, cache *TorrentCache, after time.Duration, labels []string, perTorrent bool) *TorrentStuckCollector {
+	cache.AddFields("name", "hashString", "status", "leftUntilDone", "metadataPercentComplete")
+	if perTorrent {
+		cache.AddFields(torrentLabelFields(labels)...)
+	}
+
+	sc := &TorrentStuckCollector{
+		logger: logger,
+		cache:  cache,
+		after:  after,
+		labels: labels,
+		states: make(map[int]*stuckState),
+
+		descs: newMetricDescs("torrent_stuck", labels),
+	}
+	if !perTorrent {
+		delete(sc.descs, torrentStuckSeconds)
+	}
+	cache.Observe(sc.update)
+
+	return sc
+}
+
+// update follows the state of the torrents from one cache update to the next
+func (sc *TorrentStuckCollector) update(u TorrentUpdate) {
+	sc.statesLock.Lock()
+	defer sc.statesLock.Unlock()
+
+	states := make(map[int]*stuckState, len(u.Torrents))
+	for _, t := range u.Torrents {
+		prev := sc.states[t.ID]
+		state := &stuckState{torrent: t}
+
+		if t.Status == transmission.StatusDownload {
+			if prev != nil && !prev.noProgressSince.IsZero() && prev.torrent.LeftUntilDone == t.LeftUntilDone {
+				state.noProgressSince = prev.noProgressSince
+			} else {
+				state.noProgressSince = u.Time
+			}
+		}
+		// Magnets that aren't downloading, e.g. stopped or queued ones, are paused rather than stuck.
+		downloading := t.Status == transmission.StatusDownload || t.Status == transmission.StatusDownloadWait
+		if downloading && t.MetadataPercent < 1 {
+			if prev != nil && !prev.noMetadataSince.IsZero() {
+				state.noMetadataSince = prev.noMetadataSince
+			} else {
+				state.noMetadataSince = u.Time
+			}
+		}
+		if t.Status == transmission.StatusCheckWait {
+			if prev != nil && !prev.checkWaitSince.IsZero() {
+				state.checkWaitSince = prev.checkWaitSince
+			} else {
+				state.checkWaitSince = u.Time
+			}
+		}
+
+		states[t.ID] = state
+	}
+
+	sc.states = states
+	sc.updated = u.Time
+}
+
+// StuckTorrents returns the torrents that have been stuck for longer than the configured period as
+// of the last update, longest stuck first
+func (sc *TorrentStuckCollector) StuckTorrents() []StuckTorrent {
+	sc.statesLock.Lock()
+	defer sc.statesLock.Unlock()
+
+	var stuck []StuckTorrent
+	for _, state := range sc.states {
+		since := map[string]time.Time{
+			stuckNoProgress: state.noProgressSince,
+			stuckNoMetadata: state.noMetadataSince,
+			stuckCheckWait:  state.checkWaitSince,
+		}
+		for _, reason := range stuckReasons {
+			if since[reason].IsZero() || sc.updated.Sub(since[reason]) < sc.after {
+				continue
+			}
+
+			stuck = append(stuck, StuckTorrent{
+				ID:       state.torrent.ID,
+				Name:     state.torrent.Name,
+				Hash:     state.torrent.HashString,
+				Reason:   reason,
+				Since:    since[reason],
+				Duration: sc.updated.Sub(since[reason]).Seconds(),
+				torrent:  &state.torrent,
+			})
+		}
+	}
+
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].Duration > stuck[j].Duration })
+	return stuck
+}
+
+// Describe implements the prometheus.Collector interface
+func (sc *TorrentStuckCollector) Describe(ch chan<- *prometheus.Desc) {
+	sc.descs.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface
+func (sc *TorrentStuckCollector) Collect(ch chan<- prometheus.Metric) {
+	// Make sure the cache, and with it our state, is up to date.
+	if _, err := sc.cache.Torrents(); err != nil {
+		sc.logger.Error("Failed to get torrents from Transmission.", zap.Error(err))
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, s := range sc.StuckTorrents() {
+		counts[s.Reason]++
+
+		sc.descs.Collect(ch, torrentStuckSeconds, s.Duration, append(torrentLabelValues(sc.labels, s.torrent), s.Reason)...)
+	}
+
+	for _, reason := range stuckReasons {
+		sc.descs.Collect(ch, torrentsStuck, float64(counts[reason]), reason)
+	}
+}
+
+// ServeHTTP lists the stuck torrents as JSON
+func (sc *TorrentStuckCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := sc.cache.Torrents(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	stuck := sc.StuckTorrents()
+	if stuck == nil {
+		stuck = []StuckTorrent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stuck)
+}
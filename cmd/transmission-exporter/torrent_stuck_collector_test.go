@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// newTestCache returns a TorrentCache holding the given torrents as if they had just been fetched,
+// without a Transmission daemon
+func newTestCache(t *testing.T, torrents ...transmission.Torrent) *TorrentCache {
+	client, err := transmission.New(zap.NewNop(), "http://127.0.0.1:9/transmission", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewTorrentCache(zap.NewNop(), client, nil)
+	for _, torrent := range torrents {
+		c.torrentMap[torrent.ID] = torrent
+	}
+	c.updated = time.Now()
+	return c
+}
+
+func TestTorrentStuckCollector(t *testing.T) {
+	const (
+		download     = transmission.StatusDownload
+		downloadWait = transmission.StatusDownloadWait
+		stopped      = transmission.StatusStopped
+		checkWait    = transmission.StatusCheckWait
+		check        = transmission.StatusCheck
+	)
+	start := time.Unix(1700000000, 0)
+
+	// Every step is an update a minute after the previous one, with the stuck torrents expected
+	// after it for a stuck period of 5 minutes, as reason and id.
+	type step struct {
+		torrents []transmission.Torrent
+		stuck    map[string]map[int]time.Duration
+	}
+	torrent := func(id, status int, left int64, metadata float64) transmission.Torrent {
+		return transmission.Torrent{ID: id, Status: status, LeftUntilDone: left, MetadataPercent: metadata}
+	}
+
+	for name, steps := range map[string][]step{
+		"no progress": {
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			// The timer keeps running from the first update.
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, map[string]map[int]time.Duration{stuckNoProgress: {1: 5 * time.Minute}}},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, map[string]map[int]time.Duration{stuckNoProgress: {1: 6 * time.Minute}}},
+			// Progress resets it.
+			{[]transmission.Torrent{torrent(1, download, 90, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 90, 1)}, nil},
+		},
+		"no progress while stopped": {
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, map[string]map[int]time.Duration{stuckNoProgress: {1: 5 * time.Minute}}},
+			// A status change clears the timer, which starts over when downloading again.
+			{[]transmission.Torrent{torrent(1, stopped, 100, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 100, 1)}, nil},
+		},
+		"no metadata": {
+			{[]transmission.Torrent{torrent(1, downloadWait, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 0, 0.5)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 0, 0.5)}, nil},
+			{[]transmission.Torrent{torrent(1, download, 0, 0.5)}, nil},
+			// The timer keeps running across queueing, while the download has made no progress
+			// since it started.
+			{[]transmission.Torrent{torrent(1, download, 0, 0.5)}, map[string]map[int]time.Duration{stuckNoMetadata: {1: 5 * time.Minute}}},
+			{[]transmission.Torrent{torrent(1, download, 0, 0.5)}, map[string]map[int]time.Duration{
+				stuckNoMetadata: {1: 6 * time.Minute},
+				stuckNoProgress: {1: 5 * time.Minute},
+			}},
+			// Stopped magnets are paused rather than stuck.
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0.5)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0.5)}, nil},
+		},
+		"stopped magnet": {
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+			{[]transmission.Torrent{torrent(1, stopped, 0, 0)}, nil},
+		},
+		"check wait": {
+			{[]transmission.Torrent{torrent(1, checkWait, 0, 1), torrent(2, checkWait, 0, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, checkWait, 0, 1), torrent(2, checkWait, 0, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, checkWait, 0, 1), torrent(2, checkWait, 0, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, checkWait, 0, 1), torrent(2, checkWait, 0, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, checkWait, 0, 1), torrent(2, check, 0, 1)}, nil},
+			{[]transmission.Torrent{torrent(1, checkWait, 0, 1), torrent(2, checkWait, 0, 1)}, map[string]map[int]time.Duration{stuckCheckWait: {1: 5 * time.Minute}}},
+			// Removed torrents are forgotten.
+			{[]transmission.Torrent{torrent(2, checkWait, 0, 1)}, nil},
+		},
+	} {
+		sc := NewTorrentStuckCollector(zap.NewNop(), newTestCache(t), 5*time.Minute, []string{"id"}, true)
+		for i, step := range steps {
+			now := start.Add(time.Duration(i) * time.Minute)
+			sc.update(TorrentUpdate{Time: now, Torrents: step.torrents, All: step.torrents})
+
+			stuck := make(map[string]map[int]time.Duration)
+			for _, s := range sc.StuckTorrents() {
+				if stuck[s.Reason] == nil {
+					stuck[s.Reason] = make(map[int]time.Duration)
+				}
+				stuck[s.Reason][s.ID] = time.Duration(s.Duration * float64(time.Second))
+				if want := now.Add(-time.Duration(s.Duration * float64(time.Second))); !s.Since.Equal(want) {
+					t.Errorf("%s, step %d: %s of %d since %s, want %s", name, i, s.Reason, s.ID, s.Since, want)
+				}
+			}
+			if len(stuck) != len(step.stuck) {
+				t.Errorf("%s, step %d: got stuck %v, want %v", name, i, stuck, step.stuck)
+				continue
+			}
+			for reason, ids := range step.stuck {
+				for id, d := range ids {
+					if stuck[reason][id] != d || len(stuck[reason]) != len(ids) {
+						t.Errorf("%s, step %d: got stuck %v, want %v", name, i, stuck, step.stuck)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTorrentStuckCollectorPerTorrent(t *testing.T) {
+	sc := NewTorrentStuckCollector(zap.NewNop(), newTestCache(t), time.Hour, []string{"id"}, true)
+	if !sc.descs.Has(torrentStuckSeconds) || !sc.descs.Has(torrentsStuck) {
+		t.Error("stuck metrics missing")
+	}
+	sc = NewTorrentStuckCollector(zap.NewNop(), newTestCache(t), time.Hour, []string{"id"}, false)
+	if sc.descs.Has(torrentStuckSeconds) || !sc.descs.Has(torrentsStuck) {
+		t.Error("per-torrent stuck metric exported without per-torrent metrics")
+	}
+}
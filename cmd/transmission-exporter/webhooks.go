@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// Defaults of the optional webhook settings
+const (
+	defaultWebhookRetries     = 3
+	defaultWebhookBackoff     = time.Second
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookQueueSize   = 100
+	defaultWebhookContentType = "application/json"
+)
+
+// defaultWebhookTemplate renders the event as JSON
+const defaultWebhookTemplate = `{{ json . }}`
+
+// Duration is a time.Duration that is read from JSON as a string like "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// WebhookConfig configures a webhook that torrent events are posted to
+type WebhookConfig struct {
+	// Name identifies the webhook in logs and metrics, and defaults to the host of the URL so
+	// that secrets in the URL aren't exposed
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Events are the event types to post, or all of them if empty
+	Events []string `json:"events"`
+	// Template is a Go template rendering the request body from a TorrentEvent
+	Template    string            `json:"template"`
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers"`
+	// Retries is the number of retries of a failed delivery, with a backoff doubling from Backoff
+	Retries *int     `json:"retries"`
+	Backoff Duration `json:"backoff"`
+	Timeout Duration `json:"timeout"`
+	// MinInterval rate limits deliveries to the webhook to one per interval
+	MinInterval Duration `json:"minInterval"`
+}
+
+// LoadWebhookConfigs reads a JSON file containing a list of webhook configurations
+func LoadWebhookConfigs(path string) ([]WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []WebhookConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// webhook delivers events to a single URL
+type webhook struct {
+	logger   *zap.Logger
+	client   http.Client
+	config   WebhookConfig
+	events   map[string]bool
+	template *template.Template
+	queue    chan TorrentEvent
+	retries  int
+	backoff  time.Duration
+
+	lastDelivery time.Time
+}
+
+var (
+	webhookDeliveries = registerMetric(&Metric{
+		Name:      "webhook_deliveries_total",
+		Help:      "The number of webhook deliveries by result",
+		Type:      prometheus.CounterValue,
+		Labels:    []string{"webhook", "result"},
+		Collector: "webhooks",
+	})
+	webhookDropped = registerMetric(&Metric{
+		Name:      "webhook_dropped_total",
+		Help:      "The number of events dropped because the webhook's queue was full",
+		Type:      prometheus.CounterValue,
+		Labels:    []string{"webhook"},
+		Collector: "webhooks",
+	})
+)
+
+// Webhooks posts torrent events to webhooks
+type Webhooks struct {
+	logger   *zap.Logger
+	webhooks []*webhook
+
+	delivered map[string]uint64
+	failed    map[string]uint64
+	dropped   map[string]uint64
+	lock      sync.Mutex
+
+	descs metricDescs
+}
+
+// NewWebhooks validates the webhook configurations and creates Webhooks posting the events of
+// TorrentEvents to them
+func NewWebhooks(logger *zap.Logger, events *TorrentEvents, configs []WebhookConfig) (*Webhooks, error) {
+	wh := &Webhooks{
+		logger:    logger,
+		delivered: make(map[string]uint64),
+		failed:    make(map[string]uint64),
+		dropped:   make(map[string]uint64),
+
+		descs: newMetricDescs("webhooks", nil),
+	}
+
+	names := make(map[string]bool)
+	for _, config := range configs {
+		u, err := neturl.ParseRequestURI(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook URL: %w", err)
+		}
+		if config.Name == "" {
+			config.Name = u.Host
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate webhook name %q", config.Name)
+		}
+		names[config.Name] = true
+		if config.Template == "" {
+			config.Template = defaultWebhookTemplate
+		}
+		if config.ContentType == "" {
+			config.ContentType = defaultWebhookContentType
+		}
+		if config.Timeout == 0 {
+			config.Timeout = Duration(defaultWebhookTimeout)
+		}
+
+		tmpl, err := template.New(config.Name).Funcs(template.FuncMap{"json": templateJSON}).Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for webhook %s: %w", config.Name, err)
+		}
+
+		h := &webhook{
+			logger:   logger.With(zap.String("webhook", config.Name)),
+			client:   http.Client{Timeout: time.Duration(config.Timeout)},
+			config:   config,
+			events:   make(map[string]bool),
+			template: tmpl,
+			queue:    make(chan TorrentEvent, defaultWebhookQueueSize),
+			retries:  defaultWebhookRetries,
+			backoff:  defaultWebhookBackoff,
+		}
+		if config.Retries != nil {
+			h.retries = *config.Retries
+		}
+		if config.Backoff != 0 {
+			h.backoff = time.Duration(config.Backoff)
+		}
+		for _, e := range config.Events {
+			if !slices.Contains(EventTypes, e) {
+				return nil, fmt.Errorf("unknown event type %q for webhook %s", e, config.Name)
+			}
+			h.events[e] = true
+		}
+
+		wh.webhooks = append(wh.webhooks, h)
+	}
+
+	if len(wh.webhooks) > 0 {
+		ch, _ := events.Subscribe()
+		go wh.dispatch(ch)
+		for _, h := range wh.webhooks {
+			go wh.deliver(h)
+		}
+	}
+
+	return wh, nil
+}
+
+// dispatch queues every event for the webhooks subscribed to its type
+func (wh *Webhooks) dispatch(events <-chan TorrentEvent) {
+	for e := range events {
+		for _, h := range wh.webhooks {
+			if len(h.events) > 0 && !h.events[e.Type] {
+				continue
+			}
+
+			select {
+			case h.queue <- e:
+			default:
+				h.logger.Warn("Dropped torrent event, webhook queue is full.", zap.Uint64("seq", e.Seq))
+				wh.count(wh.dropped, h.config.Name)
+			}
+		}
+	}
+}
+
+// deliver posts the queued events of a webhook one by one
+func (wh *Webhooks) deliver(h *webhook) {
+	for e := range h.queue {
+		var body bytes.Buffer
+		if err := h.template.Execute(&body, e); err != nil {
+			h.logger.Error("Failed to render webhook template.", zap.Error(err))
+			wh.count(wh.failed, h.config.Name)
+			continue
+		}
+
+		backoff := h.backoff
+		for attempt := 0; ; attempt++ {
+			if wait := time.Until(h.lastDelivery.Add(time.Duration(h.config.MinInterval))); wait > 0 {
+				time.Sleep(wait)
+			}
+			h.lastDelivery = time.Now()
+
+			retry, err := h.post(body.Bytes())
+			if err == nil {
+				wh.count(wh.delivered, h.config.Name)
+				break
+			}
+			if !retry || attempt >= h.retries {
+				h.logger.Error("Failed to deliver torrent event to webhook.", zap.Uint64("seq", e.Seq), zap.Error(err))
+				wh.count(wh.failed, h.config.Name)
+				break
+			}
+
+			h.logger.Warn("Failed to deliver torrent event to webhook, retrying.", zap.Uint64("seq", e.Seq), zap.Duration("backoff", backoff), zap.Error(err))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// post sends a request, and reports whether it is worth retrying if it failed
+func (h *webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", h.config.ContentType)
+	for k, v := range h.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// Only server errors and throttling are temporary, the webhook rejected anything else.
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return false, nil
+}
+
+func (wh *Webhooks) count(counts map[string]uint64, name string) {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+
+	counts[name]++
+}
+
+// Describe implements the prometheus.Collector interface
+func (wh *Webhooks) Describe(ch chan<- *prometheus.Desc) {
+	wh.descs.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface
+func (wh *Webhooks) Collect(ch chan<- prometheus.Metric) {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+
+	for _, h := range wh.webhooks {
+		name := h.config.Name
+		wh.descs.Collect(ch, webhookDeliveries, float64(wh.delivered[name]), name, "success")
+		wh.descs.Collect(ch, webhookDeliveries, float64(wh.failed[name]), name, "failure")
+		wh.descs.Collect(ch, webhookDropped, float64(wh.dropped[name]), name)
+	}
+}
+
+// templateJSON encodes a value as JSON, so that templates can safely embed strings in JSON bodies
+func templateJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
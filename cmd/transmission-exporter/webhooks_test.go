@@ -0,0 +1,297 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	transmission "github.com/tobz/transmission-exporter"
+	"go.uber.org/zap"
+)
+
+// webhookRequest is a request received by a webhookServer
+type webhookRequest struct {
+	time   time.Time
+	body   string
+	header http.Header
+}
+
+// webhookServer is a local stand-in for a webhook, failing the first failures requests with the
+// status, 500 by default
+type webhookServer struct {
+	*httptest.Server
+
+	failures int
+	status   int
+	requests []webhookRequest
+	lock     sync.Mutex
+}
+
+func newWebhookServer(t *testing.T, failures int) *webhookServer {
+	s := &webhookServer{failures: failures, status: http.StatusInternalServerError}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests = append(s.requests, webhookRequest{time.Now(), string(body), r.Header})
+		if len(s.requests) <= s.failures {
+			w.WriteHeader(s.status)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// waitRequests waits until the server received n requests and returns them
+func (s *webhookServer) waitRequests(t *testing.T, n int) []webhookRequest {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		requests := append([]webhookRequest(nil), s.requests...)
+		s.lock.Unlock()
+		if len(requests) >= n {
+			return requests
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d webhook requests", n)
+	return nil
+}
+
+// newTestEvents returns TorrentEvents that are published to directly, without a Transmission daemon
+func newTestEvents(t *testing.T) *TorrentEvents {
+	events, err := NewTorrentEvents(zap.NewNop(), newTestCache(t), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func newTestWebhooks(t *testing.T, configs ...WebhookConfig) (*Webhooks, *TorrentEvents) {
+	events := newTestEvents(t)
+	wh, err := NewWebhooks(zap.NewNop(), events, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wh, events
+}
+
+// assertDeliveries compares the delivery counters of the webhooks with the expected exposition
+func assertDeliveries(t *testing.T, wh *Webhooks, expected string) {
+	t.Helper()
+	expected = `
+# HELP transmission_webhook_deliveries_total The number of webhook deliveries by result
+# TYPE transmission_webhook_deliveries_total counter
+` + expected
+	// The counters are updated right after the response, so they may lag behind the server.
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if err = testutil.CollectAndCompare(wh, strings.NewReader(expected), "transmission_webhook_deliveries_total"); err == nil {
+			return
+		}
+	}
+	t.Error(err)
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	wh, events := newTestWebhooks(t, WebhookConfig{
+		Name:        "chat",
+		URL:         server.URL,
+		Events:      []string{EventCompleted},
+		Template:    `{"text": {{ json (printf "%s completed" .Name) }}, "hash": "{{ .Hash }}"}`,
+		ContentType: "application/vnd.test+json",
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+	})
+
+	torrent := transmission.Torrent{ID: 1, Name: `"quoted" name`, HashString: "abc"}
+	events.publish(EventAdded, time.Now(), torrent)
+	events.publish(EventCompleted, time.Now(), torrent)
+
+	requests := server.waitRequests(t, 1)
+	if want := `{"text": "\"quoted\" name completed", "hash": "abc"}`; requests[0].body != want {
+		t.Errorf("got body %s, want %s", requests[0].body, want)
+	}
+	if got := requests[0].header.Get("Content-Type"); got != "application/vnd.test+json" {
+		t.Errorf("got content type %q", got)
+	}
+	if got := requests[0].header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("got authorization %q", got)
+	}
+	assertDeliveries(t, wh, `
+transmission_webhook_deliveries_total{result="failure",webhook="chat"} 0
+transmission_webhook_deliveries_total{result="success",webhook="chat"} 1
+`)
+
+	// The added event isn't subscribed to, so nothing else is delivered.
+	time.Sleep(50 * time.Millisecond)
+	if n := len(server.waitRequests(t, 1)); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	retries := 3
+	server := newWebhookServer(t, 2)
+	wh, events := newTestWebhooks(t, WebhookConfig{
+		Name:    "flaky",
+		URL:     server.URL,
+		Retries: &retries,
+		Backoff: Duration(backoff),
+	})
+
+	events.publish(EventAdded, time.Now(), transmission.Torrent{ID: 1})
+
+	requests := server.waitRequests(t, 3)
+	if requests[0].body != requests[2].body {
+		t.Errorf("retried with body %s, want %s", requests[2].body, requests[0].body)
+	}
+	// The backoff doubles between attempts.
+	if d := requests[1].time.Sub(requests[0].time); d < backoff {
+		t.Errorf("first retry after %s, want at least %s", d, backoff)
+	}
+	if d := requests[2].time.Sub(requests[1].time); d < 2*backoff {
+		t.Errorf("second retry after %s, want at least %s", d, 2*backoff)
+	}
+	assertDeliveries(t, wh, `
+transmission_webhook_deliveries_total{result="failure",webhook="flaky"} 0
+transmission_webhook_deliveries_total{result="success",webhook="flaky"} 1
+`)
+}
+
+func TestWebhookFailure(t *testing.T) {
+	retries := 1
+	server := newWebhookServer(t, 100)
+	wh, events := newTestWebhooks(t, WebhookConfig{
+		Name:    "down",
+		URL:     server.URL,
+		Retries: &retries,
+		Backoff: Duration(time.Millisecond),
+	})
+
+	events.publish(EventAdded, time.Now(), transmission.Torrent{ID: 1})
+
+	server.waitRequests(t, 2)
+	assertDeliveries(t, wh, `
+transmission_webhook_deliveries_total{result="failure",webhook="down"} 1
+transmission_webhook_deliveries_total{result="success",webhook="down"} 0
+`)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(server.waitRequests(t, 2)); n != 2 {
+		t.Errorf("got %d requests, want 2 with 1 retry", n)
+	}
+}
+
+func TestWebhookRetriedStatuses(t *testing.T) {
+	retries := 2
+	for _, test := range []struct {
+		status   int
+		requests int
+	}{
+		// Server errors and throttling are retried.
+		{http.StatusServiceUnavailable, 3},
+		{http.StatusTooManyRequests, 3},
+		// Other errors are rejections, and retrying wouldn't help.
+		{http.StatusBadRequest, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusMovedPermanently, 1},
+	} {
+		server := newWebhookServer(t, 100)
+		server.status = test.status
+		wh, events := newTestWebhooks(t, WebhookConfig{
+			Name:    "rejecting",
+			URL:     server.URL,
+			Retries: &retries,
+			Backoff: Duration(time.Millisecond),
+		})
+
+		events.publish(EventAdded, time.Now(), transmission.Torrent{ID: 1})
+
+		server.waitRequests(t, test.requests)
+		assertDeliveries(t, wh, `
+transmission_webhook_deliveries_total{result="failure",webhook="rejecting"} 1
+transmission_webhook_deliveries_total{result="success",webhook="rejecting"} 0
+`)
+		if n := len(server.waitRequests(t, test.requests)); n != test.requests {
+			t.Errorf("status %d: got %d requests, want %d", test.status, n, test.requests)
+		}
+	}
+}
+
+func TestWebhookRetriedTransportErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Closing the connection without a response fails the request.
+		atomic.AddInt32(&attempts, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+	retries := 2
+	wh, events := newTestWebhooks(t, WebhookConfig{
+		Name:    "broken",
+		URL:     server.URL,
+		Retries: &retries,
+		Backoff: Duration(time.Millisecond),
+	})
+
+	events.publish(EventAdded, time.Now(), transmission.Torrent{ID: 1})
+
+	assertDeliveries(t, wh, `
+transmission_webhook_deliveries_total{result="failure",webhook="broken"} 1
+transmission_webhook_deliveries_total{result="success",webhook="broken"} 0
+`)
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("got %d attempts, want 3", n)
+	}
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	server := newWebhookServer(t, 0)
+	wh, events := newTestWebhooks(t, WebhookConfig{
+		Name:        "limited",
+		URL:         server.URL,
+		MinInterval: Duration(interval),
+	})
+
+	for id := 1; id <= 3; id++ {
+		events.publish(EventAdded, time.Now(), transmission.Torrent{ID: id})
+	}
+
+	// The interval is kept between sending the requests, so their arrival may jitter a little.
+	const jitter = 10 * time.Millisecond
+	requests := server.waitRequests(t, 3)
+	for i := 1; i < len(requests); i++ {
+		if d := requests[i].time.Sub(requests[i-1].time); d < interval-jitter {
+			t.Errorf("request %d after %s, want at least %s", i, d, interval)
+		}
+	}
+	assertDeliveries(t, wh, `
+transmission_webhook_deliveries_total{result="failure",webhook="limited"} 0
+transmission_webhook_deliveries_total{result="success",webhook="limited"} 3
+`)
+}
+
+func TestWebhookConfigErrors(t *testing.T) {
+	for name, config := range map[string]WebhookConfig{
+		"invalid URL":    {URL: "not a url"},
+		"unknown event":  {URL: "http://example.org", Events: []string{"exploded"}},
+		"invalid syntax": {URL: "http://example.org", Template: "{{ .Name"},
+	} {
+		if _, err := NewWebhooks(zap.NewNop(), newTestEvents(t), []WebhookConfig{config}); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
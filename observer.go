@@ -0,0 +1,21 @@
+package transmission
+
+import "time"
+
+// Observer receives measurements of the RPCs made by a Client, e.g. to export them as metrics. Its
+// methods may be called concurrently.
+type Observer interface {
+	// ObserveRPC is called after every RPC that got a response, with the time until the response
+	// was read and its size. The method of the session id handshake is "session-id".
+	ObserveRPC(method string, duration time.Duration, responseSize int)
+	// ObserveError is called when an RPC fails without a usable response: when the request fails,
+	// the response has an error status other than 401 and 409, or it can't be decoded
+	ObserveError(method string, err error)
+	// ObserveTokenRefresh is called when Transmission asks for a new session id
+	ObserveTokenRefresh()
+	// ObserveUnauthorized is called when Transmission rejects the credentials
+	ObserveUnauthorized()
+	// ObserveDecode is called with the time taken to decode the response of an RPC, which is
+	// streamed from the connection, so that it includes reading the response body
+	ObserveDecode(method string, duration time.Duration)
+}
@@ -42,8 +42,8 @@ type (
 		//Queue_stalled_enabled     bool   `json:"queue-stalled-enabled"`
 		//Queue_stalled_minutes     int    `json:"queue-stalled-minutes"`
 		//Rename_partial_files      bool   `json:"rename-partial-files"`
-		//RPC_version               int    `json:"rpc-version"`
-		//RPC_version_minimum          int    `json:"rpc-version-minimum"`
+		RPCVersion        int `json:"rpc-version"`
+		RPCVersionMinimum int `json:"rpc-version-minimum"`
 		//Script_torrent_done_enabled  bool   `json:"script-torrent-done-enabled"`
 		//Script_torrent_done_filename string `json:"script-torrent-done-filename"`
 		SeedQueueEnabled      bool    `json:"seed-queue-enabled"`
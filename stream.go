@@ -0,0 +1,181 @@
+package transmission
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// torrentFields maps the torrent-get field names to the indexes of the Torrent fields
+var torrentFields = func() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(Torrent{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// jsonName returns the name of a struct field in JSON, or an empty name if it's not encoded
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// decodeTorrents decodes a torrent-get response token by token, decoding only one torrent at a
+// time and calling fn with it. The torrents are expected in the table format if table is set. It
+// returns the ids of the removed torrents.
+func decodeTorrents(r io.Reader, table bool, fn func(*Torrent) error) ([]int, error) {
+	dec := json.NewDecoder(r)
+
+	var removed []int
+	err := decodeObject(dec, func(key string) error {
+		if key != "arguments" {
+			return skipValue(dec)
+		}
+		return decodeObject(dec, func(key string) error {
+			switch key {
+			case "torrents":
+				if table {
+					return decodeTorrentTable(dec, fn)
+				}
+				return decodeArray(dec, func() error {
+					var t Torrent
+					if err := dec.Decode(&t); err != nil {
+						return err
+					}
+					return fn(&t)
+				})
+			case "removed":
+				return dec.Decode(&removed)
+			default:
+				return skipValue(dec)
+			}
+		})
+	})
+	return removed, err
+}
+
+// decodeTorrentTable decodes torrents in the table format, an array of arrays whose first array
+// holds the field names and the others the values of each torrent. Values are decoded straight into
+// the fields of the Torrent, and values of unknown fields are skipped.
+func decodeTorrentTable(dec *json.Decoder, fn func(*Torrent) error) error {
+	// Rows are decoded into t through pointers to its fields, resolved once from the header, with
+	// nil pointers for the columns of unknown fields.
+	var t Torrent
+	var columns []interface{}
+	header := true
+	return decodeArray(dec, func() error {
+		if header {
+			header = false
+			var names []string
+			if err := dec.Decode(&names); err != nil {
+				return err
+			}
+			v := reflect.ValueOf(&t).Elem()
+			columns = make([]interface{}, len(names))
+			for i, name := range names {
+				if index, ok := torrentFields[name]; ok {
+					columns[i] = v.Field(index).Addr().Interface()
+				}
+			}
+			return nil
+		}
+
+		// Clearing the torrent also drops the slices of the previous row, which the decoder would
+		// otherwise reuse.
+		t = Torrent{}
+		column := 0
+		err := decodeArray(dec, func() error {
+			defer func() { column++ }()
+			if column >= len(columns) || columns[column] == nil {
+				return skipValue(dec)
+			}
+			return dec.Decode(columns[column])
+		})
+		if err != nil {
+			return err
+		}
+		row := t
+		return fn(&row)
+	})
+}
+
+// decodeObject reads a JSON object, calling fn with each key to decode its value. A null object is
+// read as an empty one.
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("expected an object, got %v", tok)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := fn(tok.(string)); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// decodeArray reads a JSON array, calling fn to decode each element. A null array is read as an
+// empty one.
+func decodeArray(dec *json.Decoder, fn func() error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected an array, got %v", tok)
+	}
+
+	for dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// skipValue reads the next JSON value without decoding it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
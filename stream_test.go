@@ -0,0 +1,423 @@
+package transmission
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// testTorrents returns n torrents with all fields set, like those of a busy daemon
+func testTorrents(n int) []Torrent {
+	torrents := make([]Torrent, n)
+	for i := range torrents {
+		hash := fmt.Sprintf("%040x", i)
+		torrents[i] = Torrent{
+			ID:             i + 1,
+			Name:           fmt.Sprintf("Some.Linux.Distribution.%d.x86_64.iso", i),
+			Status:         StatusSeed,
+			Added:          1700000000 + int64(i),
+			UploadRatio:    float64(i%300) / 100,
+			RateUpload:     i % 1000,
+			DownloadDir:    "/srv/torrents/complete",
+			IsFinished:     i%2 == 0,
+			PercentDone:    1,
+			HashString:     hash,
+			UploadedEver:   int64(i) << 20,
+			DownloadedEver: 4 << 30,
+			PeersConnected: i % 50,
+			Labels:         []string{"linux", fmt.Sprintf("batch-%d", i%10)},
+			Trackers: []Tracker{
+				{Announce: "https://tracker.example.org/announce", ID: 0, Scrape: "https://tracker.example.org/scrape"},
+				{Announce: "udp://backup.example.org:1337", ID: 1, Tier: 1},
+			},
+			MagnetLink:   "magnet:?xt=urn:btih:" + hash,
+			TotalSize:    4 << 30,
+			SizeWhenDone: 4 << 30,
+			HaveValid:    4 << 30,
+			ActivityDate: 1700000000 + int64(i),
+			PeersFrom:    PeersFrom{FromDHT: i % 7, FromPEX: i % 3, FromTracker: i % 11},
+		}
+	}
+	return torrents
+}
+
+// objectResponse returns the torrent-get response of the torrents in the object format
+func objectResponse(tb testing.TB, torrents []Torrent, removed []int) []byte {
+	tb.Helper()
+	body, err := json.Marshal(TorrentCommand{
+		Arguments: TorrentArguments{Torrents: torrents, RemovedTorrents: removed},
+		Result:    "success",
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return body
+}
+
+// tableResponse returns the torrent-get response of the fields of the torrents in the table format
+func tableResponse(tb testing.TB, torrents []Torrent, fields []string) []byte {
+	tb.Helper()
+	rows := [][]json.RawMessage{}
+	header := make([]json.RawMessage, len(fields))
+	for i, field := range fields {
+		header[i], _ = json.Marshal(field)
+	}
+	rows = append(rows, header)
+	for _, t := range torrents {
+		b, err := json.Marshal(t)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(b, &values); err != nil {
+			tb.Fatal(err)
+		}
+		row := make([]json.RawMessage, len(fields))
+		for i, field := range fields {
+			row[i] = values[field]
+		}
+		rows = append(rows, row)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"arguments": map[string]interface{}{"torrents": rows},
+		"result":    "success",
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return body
+}
+
+// collectTorrents decodes a torrent-get response and returns the torrents and removed ids
+func collectTorrents(t *testing.T, body string, table bool) ([]Torrent, []int) {
+	t.Helper()
+	var torrents []Torrent
+	removed, err := decodeTorrents(strings.NewReader(body), table, func(torrent *Torrent) error {
+		torrents = append(torrents, *torrent)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrents, removed
+}
+
+func TestDecodeTorrents(t *testing.T) {
+	body := objectResponse(t, testTorrents(100), nil)
+	var want TorrentCommand
+	if err := json.Unmarshal(body, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	torrents, removed := collectTorrents(t, string(body), false)
+	if !reflect.DeepEqual(torrents, want.Arguments.Torrents) {
+		t.Error("streamed torrents differ from the unmarshaled ones")
+	}
+	if removed != nil {
+		t.Errorf("got removed %v, want none", removed)
+	}
+}
+
+func TestDecodeTorrentsRemoved(t *testing.T) {
+	torrents, removed := collectTorrents(t, `{"arguments": {"removed": [3, 5], "torrents": [{"id": 1}]}, "result": "success"}`, false)
+	if len(torrents) != 1 || torrents[0].ID != 1 {
+		t.Errorf("got torrents %v", torrents)
+	}
+	if !reflect.DeepEqual(removed, []int{3, 5}) {
+		t.Errorf("got removed %v, want [3 5]", removed)
+	}
+}
+
+func TestDecodeTorrentsNull(t *testing.T) {
+	for _, body := range []string{
+		`{"arguments": {"torrents": null, "removed": null}, "result": "success"}`,
+		`{"arguments": {"torrents": []}, "result": "success"}`,
+		`{"arguments": null, "result": "success"}`,
+		`{"result": "success"}`,
+	} {
+		torrents, removed := collectTorrents(t, body, false)
+		if len(torrents) != 0 || len(removed) != 0 {
+			t.Errorf("%s: got torrents %v and removed %v", body, torrents, removed)
+		}
+	}
+
+	torrents, _ := collectTorrents(t, `{"arguments": {"torrents": [{"id": 1, "labels": null, "trackers": null}]}}`, false)
+	if len(torrents) != 1 || torrents[0].Labels != nil || torrents[0].Trackers != nil {
+		t.Errorf("got torrents %v", torrents)
+	}
+}
+
+func TestDecodeTorrentsUnknownFields(t *testing.T) {
+	body := `{
+		"tag": 7,
+		"debug": {"nested": [1, {"deeper": [[], {}]}], "text": "]}"},
+		"arguments": {
+			"future": [{"a": [1, 2]}, null, "x"],
+			"torrents": [{"id": 1, "unknownField": {"x": [1]}, "name": "a"}, {"id": 2, "name": "b"}],
+			"count": 2,
+			"removed": [9]
+		},
+		"result": "success"
+	}`
+	torrents, removed := collectTorrents(t, body, false)
+	if len(torrents) != 2 || torrents[0].Name != "a" || torrents[1].Name != "b" {
+		t.Errorf("got torrents %v", torrents)
+	}
+	if !reflect.DeepEqual(removed, []int{9}) {
+		t.Errorf("got removed %v, want [9]", removed)
+	}
+}
+
+func TestDecodeTorrentsErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"arguments": {"torrents": [{"id": 1}`,
+		`{"arguments": {"torrents": {"id": 1}}}`,
+		`{"arguments": {"torrents": [{"id": "one"}]}}`,
+		`[]`,
+		``,
+	} {
+		_, err := decodeTorrents(strings.NewReader(body), false, func(*Torrent) error { return nil })
+		if err == nil {
+			t.Errorf("%s: got no error", body)
+		}
+	}
+
+	// Errors of the callback stop the decoding.
+	calls := 0
+	errStop := errors.New("stop")
+	_, err := decodeTorrents(bytes.NewReader(objectResponse(t, testTorrents(3), nil)), false, func(*Torrent) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("got error %v after %d calls", err, calls)
+	}
+}
+
+func TestDecodeTorrentTable(t *testing.T) {
+	want := testTorrents(100)
+	torrents, removed := collectTorrents(t, string(tableResponse(t, want, AllTorrentFields)), true)
+	if !reflect.DeepEqual(torrents, want) {
+		t.Error("torrents decoded from the table differ from the encoded ones")
+	}
+	if removed != nil {
+		t.Errorf("got removed %v, want none", removed)
+	}
+}
+
+func TestDecodeTorrentTableColumns(t *testing.T) {
+	body := `{"arguments": {"torrents": [
+		["name", "futureField", "id", "labels"],
+		["a", {"x": [1, {}]}, 1, ["one"]],
+		["b", null, 2],
+		["c", [], 3, null, "extra", {"y": 1}]
+	], "removed": [4]}, "result": "success"}`
+	torrents, removed := collectTorrents(t, body, true)
+
+	// Columns map to fields by the header, and columns of unknown fields or without a header are
+	// skipped. Fields missing from a row stay empty rather than keeping the previous row's value.
+	want := []Torrent{
+		{ID: 1, Name: "a", Labels: []string{"one"}},
+		{ID: 2, Name: "b"},
+		{ID: 3, Name: "c"},
+	}
+	if !reflect.DeepEqual(torrents, want) {
+		t.Errorf("got torrents %+v, want %+v", torrents, want)
+	}
+	if !reflect.DeepEqual(removed, []int{4}) {
+		t.Errorf("got removed %v, want [4]", removed)
+	}
+
+	for _, body := range []string{
+		`{"arguments": {"torrents": null}}`,
+		`{"arguments": {"torrents": []}}`,
+		`{"arguments": {"torrents": [["id", "name"]]}}`,
+	} {
+		if torrents, _ := collectTorrents(t, body, true); len(torrents) != 0 {
+			t.Errorf("%s: got torrents %v", body, torrents)
+		}
+	}
+	if _, err := decodeTorrents(strings.NewReader(`{"arguments": {"torrents": [["id"], {"id": 1}]}}`), true, func(*Torrent) error { return nil }); err == nil {
+		t.Error("got no error for an object row")
+	}
+}
+
+// fakeDaemon is a Transmission daemon answering session-get with its RPC version and torrent-get
+// in the format requested, recording the formats
+type fakeDaemon struct {
+	*httptest.Server
+
+	version  int
+	token    string
+	formats  []string
+	torrents []Torrent
+	lock     sync.Mutex
+}
+
+func newFakeDaemon(t *testing.T, version int) *fakeDaemon {
+	d := &fakeDaemon{version: version, token: "token", torrents: testTorrents(3)}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		w.Header().Set("X-Transmission-Session-Id", d.token)
+		if r.Header.Get("X-Transmission-Session-Id") != d.token {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		var cmd TorrentCommand
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			t.Error(err)
+		}
+		switch cmd.Method {
+		case "session-get":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"arguments": map[string]interface{}{"rpc-version": d.version, "version": "4.0.0"},
+				"result":    "success",
+			})
+		case "torrent-get":
+			d.formats = append(d.formats, cmd.Arguments.Format)
+			if cmd.Arguments.Format == "table" {
+				w.Write(tableResponse(t, d.torrents, cmd.Arguments.Fields))
+			} else {
+				w.Write(objectResponse(t, d.torrents, nil))
+			}
+		default:
+			t.Errorf("unexpected method %s", cmd.Method)
+		}
+	}))
+	t.Cleanup(d.Close)
+	return d
+}
+
+// requestedFormats returns the formats torrents were requested in
+func (d *fakeDaemon) requestedFormats() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string(nil), d.formats...)
+}
+
+func TestStreamTorrentsFormat(t *testing.T) {
+	for _, test := range []struct {
+		version int
+		format  string
+	}{
+		{TableFormatRPCVersion - 1, ""},
+		{TableFormatRPCVersion, "table"},
+		{TableFormatRPCVersion + 1, "table"},
+	} {
+		d := newFakeDaemon(t, test.version)
+		client, err := New(zap.NewNop(), d.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			out, err := client.GetTorrents(false, "id", "name", "labels")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Torrents) != 3 || out.Torrents[2].Name != d.torrents[2].Name || len(out.Torrents[2].Labels) != 2 {
+				t.Errorf("rpc-version %d: got torrents %+v", test.version, out.Torrents)
+			}
+		}
+		if want := []string{test.format, test.format}; !reflect.DeepEqual(d.requestedFormats(), want) {
+			t.Errorf("rpc-version %d: got formats %q, want %q", test.version, d.requestedFormats(), want)
+		}
+	}
+}
+
+func TestStreamTorrentsDowngrade(t *testing.T) {
+	d := newFakeDaemon(t, TableFormatRPCVersion)
+	client, err := New(zap.NewNop(), d.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetTorrents(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A daemon restarted with an older version hands out a new session id, after which the version
+	// is learned again.
+	d.lock.Lock()
+	d.version, d.token = TableFormatRPCVersion-1, "new token"
+	d.lock.Unlock()
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetTorrents(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"table", "table", ""}; !reflect.DeepEqual(d.requestedFormats(), want) {
+		t.Errorf("got formats %q, want %q", d.requestedFormats(), want)
+	}
+}
+
+func TestSkipValue(t *testing.T) {
+	for _, value := range []string{`1.5`, `"s"`, `null`, `true`, `[]`, `{}`, `[1, [2, [3]], {"a": {"b": []}}]`, `{"a": "}", "b": [{}]}`} {
+		dec := json.NewDecoder(strings.NewReader(value + ` 42`))
+		if err := skipValue(dec); err != nil {
+			t.Errorf("%s: %v", value, err)
+			continue
+		}
+		var next int
+		if err := dec.Decode(&next); err != nil || next != 42 {
+			t.Errorf("%s: got %d and error %v after skipping", value, next, err)
+		}
+	}
+}
+
+func benchmarkDecodeTorrents(b *testing.B, n int) {
+	body := objectResponse(b, testTorrents(n), nil)
+
+	table := tableResponse(b, testTorrents(n), AllTorrentFields)
+
+	b.Run("object", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			_, err := decodeTorrents(bytes.NewReader(body), false, func(*Torrent) error { return nil })
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("table", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(table)))
+		for i := 0; i < b.N; i++ {
+			_, err := decodeTorrents(bytes.NewReader(table), true, func(*Torrent) error { return nil })
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			var cmd TorrentCommand
+			if err := json.Unmarshal(body, &cmd); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeTorrents10k(b *testing.B) {
+	benchmarkDecodeTorrents(b, 10000)
+}
+
+func BenchmarkDecodeTorrents50k(b *testing.B) {
+	benchmarkDecodeTorrents(b, 50000)
+}
@@ -1,5 +1,73 @@
 package transmission
 
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+)
+
+// Torrent statuses as reported by Transmission
+const (
+	StatusStopped = iota
+	StatusCheckWait
+	StatusCheck
+	StatusDownloadWait
+	StatusDownload
+	StatusSeedWait
+	StatusSeed
+)
+
+var statusNames = []string{
+	StatusStopped:      "stopped",
+	StatusCheckWait:    "check_wait",
+	StatusCheck:        "check",
+	StatusDownloadWait: "download_wait",
+	StatusDownload:     "download",
+	StatusSeedWait:     "seed_wait",
+	StatusSeed:         "seed",
+}
+
+// StatusName returns the name of a torrent status, e.g. "download" for StatusDownload
+func StatusName(status int) string {
+	if status < 0 || status >= len(statusNames) {
+		return "unknown"
+	}
+	return statusNames[status]
+}
+
+// ParseStatus returns the torrent status with the given name
+func ParseStatus(name string) (int, error) {
+	for status, n := range statusNames {
+		if n == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown torrent status %q", name)
+}
+
+// Torrent errors as reported by Transmission
+const (
+	ErrorNone = iota
+	ErrorTrackerWarning
+	ErrorTrackerError
+	ErrorLocalError
+)
+
+var errorNames = []string{
+	ErrorNone:           "none",
+	ErrorTrackerWarning: "tracker_warning",
+	ErrorTrackerError:   "tracker_error",
+	ErrorLocalError:     "local_error",
+}
+
+// ErrorName returns the name of a torrent error, e.g. "tracker_error" for ErrorTrackerError
+func ErrorName(code int) string {
+	if code < 0 || code >= len(errorNames) {
+		return "unknown"
+	}
+	return errorNames[code]
+}
+
 type (
 	// TorrentCommand is the root command to interact with Transmission via RPC
 	TorrentCommand struct {
@@ -11,6 +79,7 @@ type (
 	// TorrentArguments specifies the TorrentCommand in more detail
 	TorrentArguments struct {
 		Fields          []string              `json:"fields,omitempty"`
+		Format          string                `json:"format,omitempty"`
 		Torrents        []Torrent             `json:"torrents,omitempty"`
 		Ids             string                `json:"ids,omitempty"`
 		DeleteData      bool                  `json:"delete-local-data,omitempty"`
@@ -30,26 +99,67 @@ type (
 
 	// Torrent represents a transmission torrent
 	Torrent struct {
-		ID                 int     `json:"id"`
-		Name               string  `json:"name"`
-		Status             int     `json:"status"`
-		Added              int64   `json:"addedDate"`
-		LeftUntilDone      int64   `json:"leftUntilDone"`
-		Eta                int     `json:"eta"`
-		UploadRatio        float64 `json:"uploadRatio"`
-		RateDownload       int     `json:"rateDownload"`
-		RateUpload         int     `json:"rateUpload"`
-		DownloadDir        string  `json:"downloadDir"`
-		IsFinished         bool    `json:"isFinished"`
-		PercentDone        float64 `json:"percentDone"`
-		HashString         string  `json:"hashString"`
-		Error              int     `json:"error"`
-		ErrorString        string  `json:"errorString"`
-		UploadedEver       int64   `json:"uploadedEver"`
-		DownloadedEver     int64   `json:"downloadedEver"`
-		PeersConnected     int     `json:"peersConnected"`
-		PeersGettingFromUs int     `json:"peersGettingFromUs"`
-		PeersSendingToUs   int     `json:"peersSendingToUs"`
+		ID                  int       `json:"id"`
+		Name                string    `json:"name"`
+		Status              int       `json:"status"`
+		Added               int64     `json:"addedDate"`
+		LeftUntilDone       int64     `json:"leftUntilDone"`
+		Eta                 int       `json:"eta"`
+		UploadRatio         float64   `json:"uploadRatio"`
+		RateDownload        int       `json:"rateDownload"`
+		RateUpload          int       `json:"rateUpload"`
+		DownloadDir         string    `json:"downloadDir"`
+		IsFinished          bool      `json:"isFinished"`
+		PercentDone         float64   `json:"percentDone"`
+		HashString          string    `json:"hashString"`
+		Error               int       `json:"error"`
+		ErrorString         string    `json:"errorString"`
+		UploadedEver        int64     `json:"uploadedEver"`
+		DownloadedEver      int64     `json:"downloadedEver"`
+		PeersConnected      int       `json:"peersConnected"`
+		PeersGettingFromUs  int       `json:"peersGettingFromUs"`
+		PeersSendingToUs    int       `json:"peersSendingToUs"`
+		Labels              []string  `json:"labels"`
+		Trackers            []Tracker `json:"trackers"`
+		IsPrivate           bool      `json:"isPrivate"`
+		QueuePosition       int       `json:"queuePosition"`
+		Creator             string    `json:"creator"`
+		Comment             string    `json:"comment"`
+		MagnetLink          string    `json:"magnetLink"`
+		TotalSize           int64     `json:"totalSize"`
+		SizeWhenDone        int64     `json:"sizeWhenDone"`
+		HaveValid           int64     `json:"haveValid"`
+		HaveUnchecked       int64     `json:"haveUnchecked"`
+		DesiredAvailable    int64     `json:"desiredAvailable"`
+		CorruptEver         int64     `json:"corruptEver"`
+		SecondsDownloading  int64     `json:"secondsDownloading"`
+		SecondsSeeding      int64     `json:"secondsSeeding"`
+		ActivityDate        int64     `json:"activityDate"`
+		DoneDate            int64     `json:"doneDate"`
+		StartDate           int64     `json:"startDate"`
+		IsStalled           bool      `json:"isStalled"`
+		MetadataPercent     float64   `json:"metadataPercentComplete"`
+		WebseedsSendingToUs int       `json:"webseedsSendingToUs"`
+		PeersFrom           PeersFrom `json:"peersFrom"`
+	}
+
+	// PeersFrom counts the connected peers of a torrent by how they were discovered
+	PeersFrom struct {
+		FromCache    int `json:"fromCache"`
+		FromDHT      int `json:"fromDht"`
+		FromIncoming int `json:"fromIncoming"`
+		FromLPD      int `json:"fromLpd"`
+		FromLTEP     int `json:"fromLtep"`
+		FromPEX      int `json:"fromPex"`
+		FromTracker  int `json:"fromTracker"`
+	}
+
+	// Tracker is an announce URL of a torrent
+	Tracker struct {
+		Announce string `json:"announce"`
+		ID       int    `json:"id"`
+		Scrape   string `json:"scrape"`
+		Tier     int    `json:"tier"`
 	}
 
 	// ByID implements the sort Interface to sort by ID
@@ -141,3 +251,48 @@ func (t ByDate) Less(i, j int) bool { return t[i].Added < t[j].Added }
 func (t ByRatio) Len() int           { return len(t) }
 func (t ByRatio) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
 func (t ByRatio) Less(i, j int) bool { return t[i].UploadRatio < t[j].UploadRatio }
+
+// PrimaryTrackerHost returns the host of the first tracker in the lowest tier, or an empty
+// string if the torrent has no (parseable) trackers
+func (t *Torrent) PrimaryTrackerHost() string {
+	var primary *Tracker
+	for i := range t.Trackers {
+		if primary == nil || t.Trackers[i].Tier < primary.Tier {
+			primary = &t.Trackers[i]
+		}
+	}
+	if primary == nil {
+		return ""
+	}
+
+	u, err := neturl.Parse(primary.Announce)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// TrackerHosts returns the hosts of all of the torrent's trackers
+func (t *Torrent) TrackerHosts() []string {
+	hosts := make([]string, 0, len(t.Trackers))
+	for _, tracker := range t.Trackers {
+		if u, err := neturl.Parse(tracker.Announce); err == nil {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// CommentHost returns the host of the first URL found in the torrent's comment, which usually
+// points to the site the torrent was published on, or an empty string if there is none
+func (t *Torrent) CommentHost() string {
+	for _, word := range strings.Fields(t.Comment) {
+		if !strings.HasPrefix(word, "http://") && !strings.HasPrefix(word, "https://") {
+			continue
+		}
+		if u, err := neturl.Parse(word); err == nil {
+			return u.Hostname()
+		}
+	}
+	return ""
+}
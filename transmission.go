@@ -2,18 +2,30 @@ package transmission
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	neturl "net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 const RPC_PATH = "rpc/"
 
+// TableFormatRPCVersion is the first RPC version that can answer torrent-get in the table format,
+// which sends the fields of each torrent as an array instead of an object
+const TableFormatRPCVersion = 16
+
+var errUnauthorized = errors.New("authorization failed, check your username and password and make sure the ip is whitelisted")
+
 type (
 	// User to authenticate with Transmission
 	User struct {
@@ -25,63 +37,191 @@ type (
 		logger *zap.Logger
 		client http.Client
 
-		URL   string
-		token string
+		URL    string
+		token  string
+		socket string
 
 		User *User
+
+		// Observer is notified of every RPC if set
+		Observer Observer
+
+		// rpcVersion is the daemon's RPC version if rpcVersionKnown
+		rpcVersion      int
+		rpcVersionKnown bool
+		lock            sync.Mutex
 	}
 )
 
-// New create new transmission torrent
+// DefaultUnixPath is the RPC path of addresses of Unix sockets that don't give one, which is
+// Transmission's default rpc-url
+const DefaultUnixPath = "/transmission"
+
+// New create new transmission torrent. The url is either an http(s) URL like
+// http://localhost:9091/transmission, or the path of a Unix socket and the RPC path like
+// unix:/run/transmission/rpc.sock:/transmission, see ParseUnixAddr.
 func New(logger *zap.Logger, url string, user *User) (*Client, error) {
+	c := &Client{
+		logger: logger,
+		User:   user,
+	}
+
+	if socket, path, ok := ParseUnixAddr(url); ok {
+		if socket == "" {
+			return nil, fmt.Errorf("missing socket path in %q", url)
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("RPC path %q in %q doesn't start with /", path, url)
+		}
+		// Requests are sent to localhost, which Transmission's host whitelist always allows.
+		url = "http://localhost" + path
+		c.socket = socket
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		logger.Debug("Connecting to Transmission over a Unix socket.", zap.String("socket", socket))
+	}
+
 	rpcUri, err := neturl.ParseRequestURI(url)
 	if err != nil {
 		return nil, err
 	}
 
-	rpcUrl := rpcUri.JoinPath(RPC_PATH).String()
-	logger.Debug("Creating Transmission client.", zap.String("url", rpcUrl))
+	c.URL = rpcUri.JoinPath(RPC_PATH).String()
+	logger.Debug("Creating Transmission client.", zap.String("url", c.URL))
 
-	return &Client{
-		logger: logger,
-		URL:    rpcUrl,
-		User:   user,
-	}, nil
+	return c, nil
 }
 
-func (c *Client) post(body []byte) ([]byte, error) {
-	authRequest, err := c.authRequest("POST", body)
+// Addr returns the address of the RPC endpoint, which is the URL, or the path of the Unix socket
+// followed by the RPC path of the URL for Unix sockets
+func (c *Client) Addr() string {
+	if c.socket == "" {
+		return c.URL
+	}
+	u, err := neturl.Parse(c.URL)
 	if err != nil {
-		return make([]byte, 0), err
+		return "unix:" + c.socket
 	}
+	return "unix:" + c.socket + ":" + u.Path
+}
 
-	res, err := c.client.Do(authRequest)
+// ParseUnixAddr splits the address of an RPC server listening on a Unix socket, which is unix: or
+// unix:// followed by the path of the socket and optionally a colon and the RPC path, e.g.
+// unix:/run/transmission/rpc.sock:/transmission. The RPC path defaults to DefaultUnixPath. It
+// reports whether the address is one of a Unix socket.
+func ParseUnixAddr(addr string) (socket, path string, ok bool) {
+	rest := strings.TrimPrefix(addr, "unix:")
+	if rest == addr {
+		return "", "", false
+	}
+	rest = strings.TrimPrefix(rest, "//")
+
+	socket, path, found := strings.Cut(rest, ":")
+	if !found || path == "" {
+		path = DefaultUnixPath
+	}
+	return socket, path, true
+}
+
+// post sends the request body of an RPC method and decodes the response from its body with
+// decode, renewing the session id if needed. The response is never read into memory as a whole.
+func (c *Client) post(method string, body []byte, decode func(io.Reader) error) error {
+	start := time.Now()
+	res, err := c.doPost(body)
 	if err != nil {
-		return make([]byte, 0), err
+		if c.Observer != nil && !errors.Is(err, errUnauthorized) {
+			c.Observer.ObserveError(method, err)
+		}
+		return err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return make([]byte, 0), errors.New("authorization failed, check your username and password and make sure the ip is whitelisted")
+	r := &countingReader{r: res.Body}
+	decodeStart := time.Now()
+	err = decode(r)
+	if err == nil {
+		// Read anything left after the decoded value, so that the connection can be reused.
+		_, err = io.Copy(io.Discard, r)
+	}
+	if c.Observer != nil {
+		switch {
+		case r.err != nil:
+			c.Observer.ObserveError(method, r.err)
+		case err != nil:
+			// The response isn't the expected JSON, e.g. an error page of a proxy.
+			c.Observer.ObserveError(method, err)
+		default:
+			c.Observer.ObserveDecode(method, time.Since(decodeStart))
+			c.Observer.ObserveRPC(method, time.Since(start), int(r.n))
+		}
+	}
+	return err
+}
+
+// doPost sends the request body, renewing the session id if needed, and returns the response
+// with its body unread
+func (c *Client) doPost(body []byte) (*http.Response, error) {
+	authRequest, err := c.authRequest("POST", body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(authRequest)
+	if err != nil {
+		return nil, err
 	}
 
 	if res.StatusCode == http.StatusConflict {
+		res.Body.Close()
+		if c.Observer != nil {
+			c.Observer.ObserveTokenRefresh()
+		}
+		// A new session id may come from a restarted, and maybe upgraded, daemon.
+		c.setRPCVersion(0, false)
 		c.getToken()
 		authRequest, err := c.authRequest("POST", body)
 		if err != nil {
-			return make([]byte, 0), err
+			return nil, err
 		}
 		res, err = c.client.Do(authRequest)
 		if err != nil {
-			return make([]byte, 0), err
+			return nil, err
 		}
 	}
 
-	resBody, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return make([]byte, 0), err
+	if res.StatusCode == http.StatusUnauthorized {
+		res.Body.Close()
+		if c.Observer != nil {
+			c.Observer.ObserveUnauthorized()
+		}
+		return nil, errUnauthorized
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected response status %s", res.Status)
+	}
+
+	return res, nil
+}
+
+// countingReader counts the bytes read from a response body and keeps the first read error
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if err != nil && err != io.EOF && cr.err == nil {
+		cr.err = err
 	}
-	return resBody, nil
+	return n, err
 }
 
 func (c *Client) getToken() error {
@@ -94,15 +234,30 @@ func (c *Client) getToken() error {
 		req.SetBasicAuth(c.User.Username, c.User.Password)
 	}
 
+	start := time.Now()
 	res, err := c.client.Do(req)
 	if err != nil {
+		if c.Observer != nil {
+			c.Observer.ObserveError("session-id", err)
+		}
 		return err
 	}
 	defer res.Body.Close()
+	if c.Observer != nil {
+		n, _ := io.Copy(io.Discard, res.Body)
+		c.Observer.ObserveRPC("session-id", time.Since(start), int(n))
+	}
 	c.token = res.Header.Get("X-Transmission-Session-Id")
 	return nil
 }
 
+// decodeJSON returns a decode function for post that decodes the response into v
+func decodeJSON(v interface{}) func(io.Reader) error {
+	return func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(v)
+	}
+}
+
 func (c *Client) authRequest(method string, body []byte) (*http.Request, error) {
 	if c.token == "" {
 		err := c.getToken()
@@ -123,56 +278,128 @@ func (c *Client) authRequest(method string, body []byte) (*http.Request, error)
 	return req, nil
 }
 
-// GetTorrents get a list of torrents
-func (c *Client) GetTorrents(recentlyActiveOnly bool) (*TorrentArguments, error) {
+// DefaultTorrentFields are the torrent-get fields requested by GetTorrents if none are given
+var DefaultTorrentFields = []string{
+	"id",
+	"name",
+	"hashString",
+	"status",
+	"addedDate",
+	"leftUntilDone",
+	"eta",
+	"uploadRatio",
+	"rateDownload",
+	"rateUpload",
+	"downloadDir",
+	"isFinished",
+	"percentDone",
+	"error",
+	"errorString",
+	"uploadedEver",
+	"downloadedEver",
+	"peersConnected",
+	"peersGettingFromUs",
+	"peersSendingToUs",
+}
+
+// AllTorrentFields are the torrent-get fields of all Torrent fields, from their json tags
+var AllTorrentFields = func() []string {
+	var fields []string
+	t := reflect.TypeOf(Torrent{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}()
+
+// GetTorrents get a list of torrents with the given fields, or DefaultTorrentFields if none are
+// given. The id field is always requested.
+func (c *Client) GetTorrents(recentlyActiveOnly bool, fields ...string) (*TorrentArguments, error) {
+	var out TorrentArguments
+	removed, err := c.StreamTorrents(recentlyActiveOnly, func(t *Torrent) error {
+		out.Torrents = append(out.Torrents, *t)
+		return nil
+	}, fields...)
+	if err != nil {
+		return nil, err
+	}
+	out.RemovedTorrents = removed
+
+	return &out, nil
+}
+
+// StreamTorrents gets torrents like GetTorrents, but calls fn with each torrent as soon as it is
+// decoded from the response instead of collecting them, so that large responses are never held in
+// memory. It returns the ids of the removed torrents, and stops at the first error returned by fn.
+// Torrents are requested in the table format if the daemon supports it, which shrinks the response.
+func (c *Client) StreamTorrents(recentlyActiveOnly bool, fn func(*Torrent) error, fields ...string) ([]int, error) {
+	if len(fields) == 0 {
+		fields = DefaultTorrentFields
+	}
+
 	cmd := TorrentCommand{
 		Method: "torrent-get",
 		Arguments: TorrentArguments{
-			Fields: []string{
-				"id",
-				"name",
-				"hashString",
-				"status",
-				"addedDate",
-				"leftUntilDone",
-				"eta",
-				"uploadRatio",
-				"rateDownload",
-				"rateUpload",
-				"downloadDir",
-				"isFinished",
-				"percentDone",
-				"error",
-				"errorString",
-				"uploadedEver",
-				"downloadedEver",
-				"peersConnected",
-				"peersGettingFromUs",
-				"peersSendingToUs",
-			},
+			Fields: []string{"id"},
 		},
 	}
+	for _, field := range fields {
+		if field != "id" {
+			cmd.Arguments.Fields = append(cmd.Arguments.Fields, field)
+		}
+	}
 
 	if recentlyActiveOnly {
 		cmd.Arguments.Ids = "recently-active"
 	}
+	table := c.tableFormat()
+	if table {
+		cmd.Arguments.Format = "table"
+	}
 
 	req, err := json.Marshal(&cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.post(req)
+	var removed []int
+	err = c.post("torrent-get", req, func(r io.Reader) error {
+		var err error
+		removed, err = decodeTorrents(r, table, fn)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var out TorrentCommand
-	if err := json.Unmarshal(resp, &out); err != nil {
-		return nil, err
+	return removed, nil
+}
+
+// tableFormat reports whether torrent-get can be answered in the table format, getting the session
+// to learn the daemon's RPC version unless it is known
+func (c *Client) tableFormat() bool {
+	c.lock.Lock()
+	version, known := c.rpcVersion, c.rpcVersionKnown
+	c.lock.Unlock()
+
+	if !known {
+		session, err := c.GetSession()
+		if err != nil {
+			c.logger.Debug("Failed to get the RPC version, falling back to the object format.", zap.Error(err))
+			return false
+		}
+		version = session.RPCVersion
 	}
+	return version >= TableFormatRPCVersion
+}
+
+func (c *Client) setRPCVersion(version int, known bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 
-	return &out.Arguments, nil
+	c.rpcVersion, c.rpcVersionKnown = version, known
 }
 
 // GetSession gets the current session from transmission
@@ -182,15 +409,11 @@ func (c *Client) GetSession() (*Session, error) {
 		return nil, err
 	}
 
-	resp, err := c.post(req)
-	if err != nil {
-		return nil, err
-	}
-
 	var cmd SessionCommand
-	if err := json.Unmarshal(resp, &cmd); err != nil {
+	if err := c.post("session-get", req, decodeJSON(&cmd)); err != nil {
 		return nil, err
 	}
+	c.setRPCVersion(cmd.Session.RPCVersion, true)
 
 	return &cmd.Session, nil
 }
@@ -202,13 +425,8 @@ func (c *Client) GetSessionStats() (*SessionStats, error) {
 		return nil, err
 	}
 
-	resp, err := c.post(req)
-	if err != nil {
-		return nil, err
-	}
-
 	var cmd SessionStatsCmd
-	if err := json.Unmarshal(resp, &cmd); err != nil {
+	if err := c.post("session-stats", req, decodeJSON(&cmd)); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,37 @@
+package transmission
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNewUnixAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"unix:/run/transmission.sock":               "unix:/run/transmission.sock:/transmission/rpc/",
+		"unix:///run/transmission.sock":             "unix:/run/transmission.sock:/transmission/rpc/",
+		"unix:/run/transmission.sock:/":             "unix:/run/transmission.sock:/rpc/",
+		"unix:/run/transmission.sock:/transmission": "unix:/run/transmission.sock:/transmission/rpc/",
+		"unix:/run/transmission.sock:/custom/path":  "unix:/run/transmission.sock:/custom/path/rpc/",
+	} {
+		c, err := New(zap.NewNop(), addr, nil)
+		if err != nil {
+			t.Errorf("%s: %v", addr, err)
+			continue
+		}
+		if c.Addr() != want {
+			t.Errorf("%s: got address %s, want %s", addr, c.Addr(), want)
+		}
+	}
+
+	for _, addr := range []string{
+		"unix:",
+		"unix::/transmission",
+		"unix:/run/transmission.sock:transmission",
+		"unix:/run/transmission.sock:rpc/",
+	} {
+		if _, err := New(zap.NewNop(), addr, nil); err == nil {
+			t.Errorf("%s: got no error", addr)
+		}
+	}
+}